@@ -1,63 +1,74 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"syscall"
+	"time"
 
 	"github.com/lealre/httpfromtcp/internal/headers"
 	"github.com/lealre/httpfromtcp/internal/request"
 	"github.com/lealre/httpfromtcp/internal/response"
+	"github.com/lealre/httpfromtcp/internal/router"
 	"github.com/lealre/httpfromtcp/internal/server"
 )
 
 const port = 42069
-const bufferSize = 1024
+const shutdownTimeout = 10 * time.Second
 
 func main() {
-	server, err := server.Serve(port, handler)
+	server, err := server.Serve(port, server.Chain(newRouter().Serve, logRequests),
+		server.WithServerHeader("httpfromtcp"), server.WithCanonicalRedirect())
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
-	log.Println("Server gracefully stopped")
-}
 
-func handler(w *response.Writer, req *request.Request) {
-	if req.RequestLine.RequestTarget == "/yourproblem" {
-		handler400(w, req)
-		return
-	}
-	if req.RequestLine.RequestTarget == "/myproblem" {
-		handler500(w, req)
-		return
-	}
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-		handlerChunkEncoding(w, req)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	aborted, err := server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Server stopped with %d connections aborted: %v", aborted, err)
 		return
 	}
-	if req.RequestLine.RequestTarget == "/video" {
-		handlerGetVideo(w, req)
-		return
+	log.Println("Server gracefully stopped")
+}
+
+func newRouter() *router.Router {
+	r := router.New()
+	r.Get("/yourproblem", handler400)
+	r.Get("/myproblem", handler500)
+	r.Get("/httpbin/{path...}", handlerChunkEncoding)
+	r.Get("/video", handlerGetVideo)
+	r.Get("/{path...}", handler200)
+	return r
+}
+
+func logRequests(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		start := time.Now()
+		next(w, req)
+		log.Printf("%s %s %d %d bytes in %v",
+			req.RequestLine.Method, req.RequestLine.RequestTarget, w.Status(), w.BytesWritten(), time.Since(start))
 	}
-	handler200(w, req)
 }
 
 func handler400(w *response.Writer, _ *request.Request) {
 	w.WriteStatusLine(response.BadRequest)
-	body := []byte(`<html>
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(`<html>
 <head>
 <title>400 Bad Request</title>
 </head>
@@ -66,16 +77,13 @@ func handler400(w *response.Writer, _ *request.Request) {
 <p>Your request honestly kinda sucked.</p>
 </body>
 </html>
-`)
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
+`))
 }
 
 func handler500(w *response.Writer, _ *request.Request) {
 	w.WriteStatusLine(response.InternalServerError)
-	body := []byte(`<html>
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(`<html>
 <head>
 <title>500 Internal Server Error</title>
 </head>
@@ -84,16 +92,12 @@ func handler500(w *response.Writer, _ *request.Request) {
 <p>Okay, you know what? This one is on me.</p>
 </body>
 </html>
-`)
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
+`))
 }
 
 func handler200(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.Ok)
-	body := []byte(`<html>
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(`<html>
 <head>
 <title>200 OK</title>
 </head>
@@ -102,82 +106,62 @@ func handler200(w *response.Writer, _ *request.Request) {
 <p>Your request was an absolute banger.</p>
 </body>
 </html>
-`)
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
+`))
 }
 
 func handlerChunkEncoding(w *response.Writer, req *request.Request) {
-	path := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-	url := fmt.Sprintf("https://httpbin.org/%s", path)
-	resp, err := http.Get(url)
+	resp, err := http.Get(upstreamURL(req))
 	if err != nil {
-		errorBody := fmt.Sprintf("error executing endpoint: %s", err)
 		w.WriteStatusLine(response.InternalServerError)
-		h := response.GetDefaultHeaders(len(errorBody))
-		w.WriteHeaders(h)
-		w.WriteBody([]byte(errorBody))
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "error executing endpoint: %s", err)
 		return
 	}
 
 	defer resp.Body.Close()
 
 	w.WriteStatusLine(response.Ok)
-	h := response.GetDefaultHeaders(0)
-	h.Remove("content-length")
-	h.Remove("connection")
-	h.Override("Content-Type", "application/json")
-	h.Set("Transfer-Encoding", "chunked")
-	h.Set("Trailer", "X-Content-SHA256")
-	h.Set("Trailer", "X-Content-Length")
-	w.WriteHeaders(h)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Trailer", "X-Content-SHA256")
+	w.Header().Add("Trailer", "X-Content-Length")
 
-	buff := make([]byte, bufferSize)
-	body := []byte{}
-	for {
-		n, err := resp.Body.Read(buff)
-		fmt.Println("Read", n, "bytes")
-		if n > 0 {
-			_, err = w.WriteChunkedBody(buff[:n])
-			body = append(body, buff[:n]...)
-			if err != nil {
-				fmt.Println("Error writing chunked body:", err)
-				break
-			}
-		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			fmt.Println("Error reading response body:", err)
-			break
-		}
+	hash := sha256.New()
+	n, err := io.Copy(w, io.TeeReader(resp.Body, hash))
+	if err != nil {
+		fmt.Println("Error copying response body:", err)
+		return
 	}
 
 	// write trailers
 	trailersHeader := headers.NewHeaders()
-	trailersHeader.Set("X-Content-Length", strconv.Itoa(len(body)))
-	hash := sha256.Sum256(body)
-	trailersHeader.Set("X-Content-SHA256", fmt.Sprintf("%x", hash))
-	w.WriteTrailers(trailersHeader)
+	trailersHeader.Set("X-Content-Length", strconv.FormatInt(n, 10))
+	trailersHeader.Set("X-Content-SHA256", fmt.Sprintf("%x", hash.Sum(nil)))
+	// the trailers end the body, sent chunked to carry them
+	if err := w.WriteTrailers(trailersHeader); err != nil {
+		fmt.Println("Error writing trailers:", err)
+	}
+}
 
-	// finish chunk encoding
-	_, err = w.WriteChunkedBodyDone()
-	if err != nil {
-		fmt.Println("Error writing chunked body done:", err)
+// upstreamURL is the httpbin URL of the proxied request. Its host is fixed,
+// the path being re-escaped rather than pasted into the URL.
+func upstreamURL(req *request.Request) string {
+	u := url.URL{
+		Scheme:   "https",
+		Host:     "httpbin.org",
+		Path:     "/" + req.PathValue("path"),
+		RawQuery: req.URL.RawQuery,
 	}
+	return u.String()
 }
 
 func handlerGetVideo(w *response.Writer, req *request.Request) {
-	w.WriteStatusLine(response.Ok)
 	body, err := os.ReadFile("assets/vim.mp4")
 	if err != nil {
 		fmt.Printf("Error reading from assets/vim.mp4")
 		handler500(w, req)
 		return
 	}
+	w.WriteStatusLine(response.Ok)
 	h := response.GetDefaultHeaders(len(body))
 	h.Override("Content-Type", "video/mp4")
 	w.WriteHeaders(h)
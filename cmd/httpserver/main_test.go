@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/lealre/httpfromtcp/internal/request"
+	"github.com/lealre/httpfromtcp/internal/response"
+	"github.com/lealre/httpfromtcp/internal/router"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamURL(t *testing.T) {
+	upstream := func(target string) *url.URL {
+		var got string
+		r := router.New()
+		r.Get("/httpbin/{path...}", func(w *response.Writer, req *request.Request) {
+			got = upstreamURL(req)
+		})
+		req, err := request.RequestFromReader(strings.NewReader("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		r.Serve(response.NewWriter(&buf), req)
+		require.NotEmpty(t, got)
+		u, err := url.Parse(got)
+		require.NoError(t, err)
+		return u
+	}
+
+	// Test: Path and query are proxied
+	u := upstream("/httpbin/stream/3?n=1&b=%20")
+	assert.Equal(t, "https", u.Scheme)
+	assert.Equal(t, "httpbin.org", u.Host)
+	assert.Equal(t, "/stream/3", u.Path)
+	assert.Equal(t, "n=1&b=%20", u.RawQuery)
+
+	// Test: An encoded slash can't change the upstream host
+	u = upstream("/httpbin%2F@evil.example/steal")
+	assert.Equal(t, "httpbin.org", u.Host)
+	assert.Nil(t, u.User)
+
+	// Test: Neither can userinfo in the path
+	u = upstream("/httpbin/@evil.example/steal")
+	assert.Equal(t, "httpbin.org", u.Host)
+	assert.Nil(t, u.User)
+}
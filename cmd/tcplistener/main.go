@@ -37,12 +37,16 @@ func main() {
 		fmt.Printf("- Version: %s\n", response.RequestLine.HttpVersion)
 
 		fmt.Println("Headers:")
-		for key, value := range response.Headers {
+		for key, value := range response.Headers.All() {
 			fmt.Printf("- %s: %s\n", key, value)
 		}
 
 		fmt.Println("Body:")
-		fmt.Println(string(response.Body))
+		body, err := response.ReadBody()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(body))
 
 		conn.Close()
 		log.Printf("Connection from %s closed", conn.RemoteAddr().String())
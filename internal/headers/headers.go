@@ -2,20 +2,38 @@ package headers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"iter"
 	"slices"
 	"strings"
 )
 
 const crlf = "\r\n"
 
-type Headers map[string]string
+var (
+	ErrMalformedFieldLine = errors.New("malformed field line")
+	ErrInvalidFieldName   = errors.New("invalid field name")
+	ErrInvalidFieldValue  = errors.New("invalid field value")
+)
+
+// Headers holds the field lines of a message in the order they were added.
+// Names are stored in their canonical form, like Content-Length, while
+// lookups are case-insensitive.
+type Headers struct {
+	fields []field
+}
 
-func NewHeaders() Headers {
-	return map[string]string{}
+type field struct {
+	name  string
+	value string
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+func NewHeaders() *Headers {
+	return &Headers{}
+}
+
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 	idx := bytes.Index(data, []byte(crlf))
 	if idx == -1 {
 		return 0, false, nil
@@ -26,49 +44,163 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		return 2, true, nil
 	}
 
+	if data[0] == ' ' || data[0] == '\t' {
+		// an obs-fold or a name after whitespace, which another parser
+		// could read as part of the previous field line
+		return 0, false, fmt.Errorf("%w: leading whitespace: %q", ErrMalformedFieldLine, data[:idx])
+	}
+
 	parts := bytes.SplitN(data[:idx], []byte(":"), 2)
-	key := strings.ToLower(string(parts[0]))
+	if len(parts) != 2 {
+		return 0, false, fmt.Errorf("%w: %s", ErrMalformedFieldLine, data[:idx])
+	}
+	key := string(parts[0])
 
 	if key != strings.TrimRight(key, " ") {
-		return 0, false, fmt.Errorf("invalid header name: %s", key)
+		return 0, false, fmt.Errorf("%w: %s", ErrInvalidFieldName, key)
 	}
 
 	value := bytes.TrimSpace(parts[1])
-	key = strings.TrimSpace(key)
-	if !validTokens([]byte(key)) {
-		return 0, false, fmt.Errorf("invalid header token found: %s", key)
+	if len(key) == 0 || !validTokens([]byte(key)) {
+		return 0, false, fmt.Errorf("%w: invalid token found: %s", ErrInvalidFieldName, key)
 	}
 
-	h.Set(key, string(value))
+	if !ValidValue(string(value)) {
+		return 0, false, fmt.Errorf("%w for %s", ErrInvalidFieldValue, key)
+	}
+
+	h.Add(key, string(value))
 	return idx + 2, false, nil
 }
 
-func (h Headers) Set(key, value string) {
-	key = strings.ToLower(key)
-	if existingValue, contains := h[key]; contains {
-		newValue := fmt.Sprintf("%s, %s", existingValue, value)
-		h[key] = newValue
-	} else {
-		h[key] = value
+// Add appends a field line for key, kept on a line of its own when the
+// headers are written
+func (h *Headers) Add(key, value string) {
+	h.fields = append(h.fields, field{name: CanonicalName(key), value: value})
+}
+
+// Set replaces the values of key with value, like Override. Use Add for
+// fields sent on several lines.
+func (h *Headers) Set(key, value string) {
+	h.Override(key, value)
+}
+
+// Get returns the values of key combined into a single comma separated
+// value, or "" if there is none. Use Values for fields that can't be
+// combined, like Set-Cookie.
+func (h *Headers) Get(key string) string {
+	return strings.Join(h.Values(key), ", ")
+}
+
+// Values returns the values of key, one per field line
+func (h *Headers) Values(key string) []string {
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			values = append(values, f.value)
+		}
+	}
+	return values
+}
+
+// Override replaces all the values of key with value, which takes the place
+// of the first field line of key
+func (h *Headers) Override(key, value string) {
+	idx := slices.IndexFunc(h.fields, func(f field) bool {
+		return strings.EqualFold(f.name, key)
+	})
+	if idx == -1 {
+		h.Add(key, value)
+		return
+	}
+	h.Remove(key)
+	h.fields = slices.Insert(h.fields, idx, field{name: CanonicalName(key), value: value})
+}
+
+func (h *Headers) Remove(key string) {
+	h.fields = slices.DeleteFunc(h.fields, func(f field) bool {
+		return strings.EqualFold(f.name, key)
+	})
+}
+
+// Len returns the number of field lines
+func (h *Headers) Len() int {
+	return len(h.fields)
+}
+
+// All iterates over the field lines in order, yielding their canonical name
+// and their value
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for _, f := range h.fields {
+			if !yield(f.name, f.value) {
+				return
+			}
+		}
 	}
 }
 
-func (h Headers) Get(key string) string {
-	if value, contains := h[key]; contains {
-		return value
+// CanonicalName returns the canonical form of a field name: the first letter
+// and the letters following a hyphen in upper case, the rest in lower case
+func CanonicalName(name string) string {
+	b := []byte(name)
+	upper := true
+	for i, c := range b {
+		if upper && c >= 'a' && c <= 'z' {
+			b[i] = c - 'a' + 'A'
+		} else if !upper && c >= 'A' && c <= 'Z' {
+			b[i] = c - 'A' + 'a'
+		}
+		upper = c == '-'
 	}
+	return string(b)
+}
 
-	return ""
+// HasToken reports whether the comma separated list of tokens in the value
+// of key contains token, compared case-insensitively
+func (h *Headers) HasToken(key, token string) bool {
+	for _, value := range h.Values(key) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func (h Headers) Override(key, value string) {
-	key = strings.ToLower(key)
-	h[key] = value
+// Validate checks that every field line can be written as is: a token as
+// name and a value without control characters, so no header can inject CR
+// or LF into a message
+func (h *Headers) Validate() error {
+	for _, f := range h.fields {
+		if len(f.name) == 0 || !validTokens([]byte(f.name)) {
+			return fmt.Errorf("%w: %q", ErrInvalidFieldName, f.name)
+		}
+		if !ValidValue(f.value) {
+			return fmt.Errorf("%w for %s: %q", ErrInvalidFieldValue, f.name, f.value)
+		}
+	}
+	return nil
 }
 
-func (h Headers) Remove(key string) {
-	key = strings.ToLower(key)
-	delete(h, key)
+// ValidValue reports whether value is a valid field value as defined in
+// RFC 9110 section 5.5: visible characters, obs-text, spaces and tabs, with
+// no leading or trailing whitespace
+func ValidValue(value string) bool {
+	if value != strings.Trim(value, " \t") {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '\t' {
+			continue
+		}
+		if c < ' ' || c == 0x7f {
+			return false
+		}
+	}
+	return true
 }
 
 var tokenChars = []byte{'!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~'}
@@ -14,25 +14,51 @@ func TestHeadersParse(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
 	// Test: Invalid spacing header
 	headers = NewHeaders()
-	data = []byte("       Host : localhost:42069       \r\n\r\n")
+	data = []byte("Host : localhost:42069       \r\n\r\n")
 	n, done, err = headers.Parse(data)
-	require.Error(t, err)
+	require.ErrorIs(t, err, ErrInvalidFieldName)
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 
-	// Test: Valid single header with extra whitespace
+	// Test: Missing colon
 	headers = NewHeaders()
-	data = []byte("       Host: localhost:42069       \r\n\r\n")
+	data = []byte("Host localhost\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.ErrorIs(t, err, ErrMalformedFieldLine)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Empty field name
+	headers = NewHeaders()
+	data = []byte(": localhost\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.ErrorIs(t, err, ErrInvalidFieldName)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Valid single header with extra whitespace around the value
+	headers = NewHeaders()
+	data = []byte("Host:       localhost:42069       \r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	assert.Equal(t, len(data)-2, n)
 	assert.False(t, done)
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
+
+	// Test: Leading whitespace, a folded line or a name after padding
+	for _, line := range []string{"       Host: localhost:42069\r\n\r\n", "\tcontinued\r\n\r\n"} {
+		headers = NewHeaders()
+		n, done, err = headers.Parse([]byte(line))
+		require.ErrorIs(t, err, ErrMalformedFieldLine)
+		assert.Equal(t, 0, n)
+		assert.False(t, done)
+	}
 
 	// Test: Valid done
 	headers = NewHeaders()
@@ -50,14 +76,14 @@ func TestHeadersParse(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, len(data1), n)
 	assert.False(t, done)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	// Second header (remaining data)
 	data2 := []byte("User-Agent: Go-HTTP-Parser\r\n\r\n")
 	n, done, err = headers.Parse(data2)
 	require.NoError(t, err)
 	assert.Equal(t, len(data2)-2, n)
 	assert.False(t, done)
-	assert.Equal(t, "Go-HTTP-Parser", headers["user-agent"])
+	assert.Equal(t, "Go-HTTP-Parser", headers.Get("user-agent"))
 
 	// Test: Case-insensitive header merging
 	headers = NewHeaders()
@@ -67,13 +93,135 @@ func TestHeadersParse(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, len(data1), n)
 	assert.False(t, done)
-	assert.Equal(t, "name1", headers["set-person"])
+	assert.Equal(t, "name1", headers.Get("set-person"))
 	// Second header
 	data2 = []byte("set-person: name2\r\n\r\n")
 	n, done, err = headers.Parse(data2)
 	require.NoError(t, err)
 	assert.Equal(t, len(data2)-2, n)
 	assert.False(t, done)
-	assert.Equal(t, "name1, name2", headers["set-person"])
+	assert.Equal(t, "name1, name2", headers.Get("set-person"))
+	assert.Equal(t, []string{"name1", "name2"}, headers.Values("set-person"))
+}
+
+func TestHeadersMultipleValues(t *testing.T) {
+	// Test: Add keeps every field line
+	headers := NewHeaders()
+	headers.Add("Set-Cookie", "a=1; Path=/")
+	headers.Add("set-cookie", "b=2, c=3")
+	assert.Equal(t, []string{"a=1; Path=/", "b=2, c=3"}, headers.Values("Set-Cookie"))
+	assert.Equal(t, "a=1; Path=/, b=2, c=3", headers.Get("SET-COOKIE"))
+
+	// Test: Override replaces every value
+	headers.Override("Set-Cookie", "d=4")
+	assert.Equal(t, []string{"d=4"}, headers.Values("set-cookie"))
+
+	// Test: Set replaces the previous value, keeping its place
+	headers.Set("Content-Type", "text/html")
+	headers.Set("content-type", "text/plain")
+	assert.Equal(t, []string{"text/plain"}, headers.Values("Content-Type"))
+	headers.Set("Set-Cookie", "e=5")
+	assert.Equal(t, []string{"e=5"}, headers.Values("set-cookie"))
+	assert.Equal(t, 2, headers.Len())
+
+	// Test: Missing key
+	assert.Empty(t, headers.Values("x-missing"))
+	assert.Equal(t, "", headers.Get("x-missing"))
+
+	// Test: Tokens are found across field lines
+	headers.Add("Connection", "keep-alive")
+	headers.Add("Connection", "Upgrade, Close")
+	assert.True(t, headers.HasToken("connection", "close"))
+	assert.True(t, headers.HasToken("Connection", "upgrade"))
+	assert.False(t, headers.HasToken("connection", "chunked"))
+
+	// Test: Remove deletes every value
+	headers.Remove("CONNECTION")
+	assert.Empty(t, headers.Values("connection"))
+}
+
+func TestHeadersOrderAndCasing(t *testing.T) {
+	// Test: Field lines keep their insertion order and canonical names
+	headers := NewHeaders()
+	headers.Add("content-type", "text/plain")
+	headers.Add("X-REQUEST-ID", "abc")
+	headers.Add("set-cookie", "a=1")
+	headers.Add("Content-Length", "5")
+	headers.Add("Set-Cookie", "b=2")
+	lines := []string{}
+	for name, value := range headers.All() {
+		lines = append(lines, name+": "+value)
+	}
+	assert.Equal(t, []string{
+		"Content-Type: text/plain",
+		"X-Request-Id: abc",
+		"Set-Cookie: a=1",
+		"Content-Length: 5",
+		"Set-Cookie: b=2",
+	}, lines)
+	assert.Equal(t, 5, headers.Len())
+
+	// Test: Override keeps the position of the first field line
+	headers.Override("SET-COOKIE", "c=3")
+	lines = []string{}
+	for name, value := range headers.All() {
+		lines = append(lines, name+": "+value)
+	}
+	assert.Equal(t, []string{
+		"Content-Type: text/plain",
+		"X-Request-Id: abc",
+		"Set-Cookie: c=3",
+		"Content-Length: 5",
+	}, lines)
+
+	// Test: Override of a missing key appends it
+	headers.Override("connection", "close")
+	assert.Equal(t, "close", headers.Get("Connection"))
+	assert.Equal(t, 5, headers.Len())
+
+	// Test: Canonical names
+	assert.Equal(t, "Content-Length", CanonicalName("content-length"))
+	assert.Equal(t, "Www-Authenticate", CanonicalName("WWW-AUTHENTICATE"))
+	assert.Equal(t, "X-Content-Sha256", CanonicalName("X-Content-SHA256"))
+}
+
+func TestHeadersValueValidation(t *testing.T) {
+	// Test: Control characters in a parsed value
+	headers := NewHeaders()
+	data := []byte("X-Name: foo\x00bar\r\n\r\n")
+	n, done, err := headers.Parse(data)
+	require.ErrorIs(t, err, ErrInvalidFieldValue)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Bare CR in a parsed value
+	headers = NewHeaders()
+	data = []byte("X-Name: foo\rbar\r\n\r\n")
+	_, _, err = headers.Parse(data)
+	require.ErrorIs(t, err, ErrInvalidFieldValue)
+
+	// Test: Tabs, spaces and obs-text are allowed
+	headers = NewHeaders()
+	data = []byte("X-Name: foo\tbar baz \xe9\r\n\r\n")
+	_, _, err = headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, "foo\tbar baz \xe9", headers.Get("x-name"))
+
+	// Test: Values set by code are checked before writing
+	headers = NewHeaders()
+	headers.Set("Content-Type", "text/plain")
+	require.NoError(t, headers.Validate())
+	headers.Set("Location", "/next\r\nSet-Cookie: injected=1")
+	require.ErrorIs(t, headers.Validate(), ErrInvalidFieldValue)
+
+	headers = NewHeaders()
+	headers.Set("X-Bad Name", "value")
+	require.ErrorIs(t, headers.Validate(), ErrInvalidFieldName)
 
+	// Test: Single values
+	assert.True(t, ValidValue(""))
+	assert.True(t, ValidValue("text/html; charset=utf-8"))
+	assert.False(t, ValidValue("a\nb"))
+	assert.False(t, ValidValue("a\x7fb"))
+	assert.False(t, ValidValue(" leading"))
 }
@@ -0,0 +1,153 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrFormTooLarge       = errors.New("form body too large")
+	ErrInvalidForm        = errors.New("invalid form body")
+	ErrUnsupportedCharset = errors.New("unsupported form charset")
+)
+
+// ParseForm fills Form with the query parameters and, for POST, PUT and
+// PATCH requests with an application/x-www-form-urlencoded body, PostForm
+// with the body parameters. Form holds both, the body values coming first.
+// The body is read up to Limits.MaxFormBytes, failing with ErrFormTooLarge
+// past it. Bodies in UTF-8, US-ASCII and ISO-8859-1 are accepted, other
+// charsets fail with ErrUnsupportedCharset, the query parameters still being
+// available in Form.
+//
+// Parsing happens once, later calls return nil.
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+	r.Form = Values{}
+	r.PostForm = Values{}
+
+	var err error
+	if r.hasFormBody() {
+		var values Values
+		if values, err = r.readFormBody(); err == nil {
+			r.PostForm = values
+			for key, vs := range values {
+				r.Form[key] = append(r.Form[key], vs...)
+			}
+		}
+	}
+	if r.URL != nil {
+		for key, vs := range r.URL.Query {
+			r.Form[key] = append(r.Form[key], vs...)
+		}
+	}
+	return err
+}
+
+// FormValue returns the first value of key in Form, parsing the form first
+// if needed. Parsing errors are ignored, use ParseForm to get them.
+func (r *Request) FormValue(key string) string {
+	r.ParseForm()
+	return r.Form.Get(key)
+}
+
+// hasFormBody reports whether the body of r is an urlencoded form to parse
+func (r *Request) hasFormBody() bool {
+	switch r.RequestLine.Method {
+	case "POST", "PUT", "PATCH":
+	default:
+		return false
+	}
+	mediaType, _ := parseMediaType(r.Headers.Get("Content-Type"))
+	return mediaType == "application/x-www-form-urlencoded"
+}
+
+func (r *Request) readFormBody() (Values, error) {
+	_, params := parseMediaType(r.Headers.Get("Content-Type"))
+	charset := strings.ToLower(params["charset"])
+	switch charset {
+	case "", "utf-8", "us-ascii", "iso-8859-1", "latin1":
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCharset, charset)
+	}
+
+	body := io.Reader(r.Body)
+	if r.limits.MaxFormBytes > 0 {
+		body = io.LimitReader(r.Body, int64(r.limits.MaxFormBytes)+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if exceeds(len(data), r.limits.MaxFormBytes) {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrFormTooLarge, r.limits.MaxFormBytes)
+	}
+
+	values, err := ParseQuery(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidForm, err)
+	}
+	decoded := Values{}
+	for key, vs := range values {
+		decodedKey, err := decodeCharset(key, charset)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vs {
+			decodedValue, err := decodeCharset(v, charset)
+			if err != nil {
+				return nil, err
+			}
+			decoded.Add(decodedKey, decodedValue)
+		}
+	}
+	return decoded, nil
+}
+
+// decodeCharset converts s, decoded from a form in charset, to UTF-8
+func decodeCharset(s, charset string) (string, error) {
+	switch charset {
+	case "iso-8859-1", "latin1":
+		// every byte is the code point of the same value
+		var b strings.Builder
+		for i := 0; i < len(s); i++ {
+			b.WriteRune(rune(s[i]))
+		}
+		return b.String(), nil
+	case "us-ascii":
+		for i := 0; i < len(s); i++ {
+			if s[i] >= 0x80 {
+				return "", fmt.Errorf("%w: non ASCII byte in %q", ErrInvalidForm, s)
+			}
+		}
+	default:
+		if !utf8.ValidString(s) {
+			return "", fmt.Errorf("%w: invalid UTF-8 in %q", ErrInvalidForm, s)
+		}
+	}
+	return s, nil
+}
+
+// parseMediaType splits a Content-Type value into its media type, in lower
+// case, and its parameters, with names in lower case and values unquoted
+func parseMediaType(value string) (string, map[string]string) {
+	parts := strings.Split(value, ";")
+	mediaType := strings.ToLower(strings.TrimSpace(parts[0]))
+	params := map[string]string{}
+	for _, part := range parts[1:] {
+		name, v, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+			v = strings.ReplaceAll(v[1:len(v)-1], `\"`, `"`)
+		}
+		params[strings.ToLower(strings.TrimSpace(name))] = v
+	}
+	return mediaType, params
+}
@@ -0,0 +1,406 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/lealre/httpfromtcp/internal/headers"
+)
+
+var (
+	ErrNotMultipart       = errors.New("request body isn't multipart/form-data")
+	ErrMalformedMultipart = errors.New("malformed multipart body")
+	ErrPartTooLarge       = errors.New("multipart part too large")
+	ErrMultipartTooLarge  = errors.New("multipart body too large")
+	ErrTooManyParts       = errors.New("too many multipart parts")
+)
+
+// MultipartReader reads the parts of a multipart/form-data body one after
+// the other, streaming their content from the request body. Its size is
+// bounded by Limits.MaxMultipartBytes, MaxPartBytes and MaxParts.
+type MultipartReader struct {
+	br *bufio.Reader
+	// delimiter separates the parts: CRLF, "--" and the boundary
+	delimiter []byte
+	limits    Limits
+	current   *Part
+	parts     int
+	done      bool
+}
+
+// Part is a part of a multipart body, its content being read from the part
+// itself
+type Part struct {
+	Headers *headers.Headers
+
+	mr          *MultipartReader
+	disposition string
+	params      map[string]string
+	read        int
+	eof         bool
+}
+
+// MultipartReader returns a reader over the parts of a multipart/form-data
+// body. It fails with ErrNotMultipart if the Content-Type of r isn't one
+// with a valid boundary.
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	mediaType, params := parseMediaType(r.Headers.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return nil, fmt.Errorf("%w: %s", ErrNotMultipart, mediaType)
+	}
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > 70 || strings.HasSuffix(boundary, " ") {
+		return nil, fmt.Errorf("%w: bad boundary %q", ErrNotMultipart, boundary)
+	}
+
+	var body io.Reader = r.Body
+	if r.limits.MaxMultipartBytes > 0 {
+		body = &limitReader{
+			reader:    r.Body,
+			limit:     r.limits.MaxMultipartBytes,
+			remaining: r.limits.MaxMultipartBytes,
+		}
+	}
+	// with a CRLF before the body, the first boundary is a delimiter like
+	// the others and the preamble is skipped like a part
+	return &MultipartReader{
+		br:        bufio.NewReaderSize(io.MultiReader(strings.NewReader(crlf), body), bufferSize),
+		delimiter: []byte(crlf + "--" + boundary),
+		limits:    r.limits,
+	}, nil
+}
+
+// NextPart returns the next part of the body, skipping what wasn't read of
+// the current one. It returns io.EOF after the last part.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+	current := mr.current
+	if current == nil {
+		current = &Part{mr: mr}
+	}
+	if _, err := io.Copy(io.Discard, current); err != nil {
+		return nil, err
+	}
+
+	if _, err := mr.br.Discard(len(mr.delimiter)); err != nil {
+		return nil, mr.unexpected(err)
+	}
+	if next, _ := mr.br.Peek(2); string(next) == "--" {
+		// the close delimiter, whose CRLF is optional, what follows is an
+		// epilogue to ignore
+		mr.done = true
+		return nil, io.EOF
+	}
+	line, err := mr.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimLeft(line, " \t") != crlf {
+		return nil, fmt.Errorf("%w: garbage after the boundary", ErrMalformedMultipart)
+	}
+
+	mr.parts++
+	if exceeds(mr.parts, mr.limits.MaxParts) {
+		return nil, fmt.Errorf("%w: more than %d", ErrTooManyParts, mr.limits.MaxParts)
+	}
+	h, err := mr.readHeaders()
+	if err != nil {
+		return nil, err
+	}
+	part := &Part{Headers: h, mr: mr}
+	part.disposition, part.params = parseMediaType(h.Get("Content-Disposition"))
+	mr.current = part
+	return part, nil
+}
+
+// readHeaders parses the field lines of a part, up to the empty line
+func (mr *MultipartReader) readHeaders() (*headers.Headers, error) {
+	h := headers.NewHeaders()
+	headerBytes := 0
+	for {
+		line, err := mr.readLine()
+		if err != nil {
+			return nil, err
+		}
+		headerBytes += len(line)
+		if exceeds(headerBytes, mr.limits.MaxHeaderBytes) || exceeds(h.Len(), mr.limits.MaxHeaderCount) {
+			return nil, fmt.Errorf("%w: part headers too large", ErrMalformedMultipart)
+		}
+		_, done, err := h.Parse([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedMultipart, err)
+		}
+		if done {
+			return h, nil
+		}
+	}
+}
+
+// readLine returns the next line, CRLF included
+func (mr *MultipartReader) readLine() (string, error) {
+	line, err := mr.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("%w: line too long", ErrMalformedMultipart)
+	}
+	if err != nil {
+		return "", mr.unexpected(err)
+	}
+	if !bytes.HasSuffix(line, []byte(crlf)) {
+		return "", fmt.Errorf("%w: line not ended by CRLF", ErrMalformedMultipart)
+	}
+	return string(line), nil
+}
+
+// unexpected converts the end of the body in the middle of a part into an
+// error
+func (mr *MultipartReader) unexpected(err error) error {
+	if err == io.EOF {
+		return fmt.Errorf("%w: unexpected end of body", ErrMalformedMultipart)
+	}
+	return err
+}
+
+// FormName returns the name of the form field of the part, or "" if it has
+// none
+func (p *Part) FormName() string {
+	if p.disposition != "form-data" {
+		return ""
+	}
+	return p.params["name"]
+}
+
+// FileName returns the name of the uploaded file of the part, without any
+// directory, or "" if the part isn't a file
+func (p *Part) FileName() string {
+	name := p.params["filename"]
+	if idx := strings.LastIndexAny(name, `/\`); idx != -1 {
+		name = name[idx+1:]
+	}
+	return name
+}
+
+// Read reads the content of the part, returning io.EOF at its end
+func (p *Part) Read(b []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	mr := p.mr
+	// once the delimiter is buffered or the body is over, what is
+	// before the delimiter belongs to the part
+	data, err := mr.br.Peek(mr.br.Size())
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	available := len(data)
+	idx := bytes.Index(data, mr.delimiter)
+	switch {
+	case idx == 0:
+		p.eof = true
+		return 0, io.EOF
+	case idx > 0:
+		available = idx
+	case err == io.EOF:
+		return 0, mr.unexpected(err)
+	default:
+		// the end of the buffer could be the start of the delimiter
+		available -= len(mr.delimiter) - 1
+	}
+
+	n := copy(b, data[:available])
+	mr.br.Discard(n)
+	p.read += n
+	if exceeds(p.read, mr.limits.MaxPartBytes) {
+		return n, fmt.Errorf("%w: more than %d bytes", ErrPartTooLarge, mr.limits.MaxPartBytes)
+	}
+	return n, nil
+}
+
+// limitReader fails with ErrMultipartTooLarge once more than limit bytes
+// were read
+type limitReader struct {
+	reader    io.Reader
+	limit     int
+	remaining int
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrMultipartTooLarge, l.limit)
+	}
+	if len(p) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.reader.Read(p)
+	l.remaining -= n
+	if l.remaining < 0 {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrMultipartTooLarge, l.limit)
+	}
+	return n, err
+}
+
+// MultipartForm is a parsed multipart/form-data body
+type MultipartForm struct {
+	Value Values
+	File  map[string][]*FileHeader
+}
+
+// FileHeader describes an uploaded file, whose content is held in memory or
+// in a temporary file
+type FileHeader struct {
+	Filename string
+	Headers  *headers.Headers
+	Size     int64
+
+	content []byte
+	tmpFile string
+}
+
+// File is the content of an uploaded file
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Open returns the content of the file
+func (fh *FileHeader) Open() (File, error) {
+	if fh.tmpFile != "" {
+		return os.Open(fh.tmpFile)
+	}
+	return memoryFile{bytes.NewReader(fh.content)}, nil
+}
+
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error {
+	return nil
+}
+
+// RemoveAll removes the temporary files of the form
+func (f *MultipartForm) RemoveAll() error {
+	var errs []error
+	for _, fhs := range f.File {
+		for _, fh := range fhs {
+			if fh.tmpFile == "" {
+				continue
+			}
+			if err := os.Remove(fh.tmpFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ParseMultipartForm reads a whole multipart/form-data body into
+// MultipartForm. The values of the fields and the uploaded files are kept in
+// memory while they take up to maxMemory bytes in total. Past it, files are
+// written to temporary files, removed by the server once the handler
+// returns, while values fail with ErrMultipartTooLarge. The values of the
+// fields are also added to Form and PostForm.
+//
+// Parsing happens once, later calls return nil.
+func (r *Request) ParseMultipartForm(maxMemory int64) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	form := &MultipartForm{Value: Values{}, File: map[string][]*FileHeader{}}
+	if err := form.read(mr, maxMemory); err != nil {
+		form.RemoveAll()
+		return err
+	}
+	r.MultipartForm = form
+
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	for key, vs := range form.Value {
+		r.Form[key] = append(r.Form[key], vs...)
+		r.PostForm[key] = append(r.PostForm[key], vs...)
+	}
+	return nil
+}
+
+func (f *MultipartForm) read(mr *MultipartReader, maxMemory int64) error {
+	// one byte past the budget tells whether it was exceeded
+	maxMemory = min(max(maxMemory, 0), math.MaxInt64-1)
+	budget := maxMemory
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxMemory+1))
+			if err != nil {
+				return err
+			}
+			if int64(len(value)) > maxMemory {
+				return fmt.Errorf("%w: form values over %d bytes", ErrMultipartTooLarge, budget)
+			}
+			f.Value.Add(name, string(value))
+			maxMemory -= int64(len(value))
+			continue
+		}
+
+		fh := &FileHeader{Filename: part.FileName(), Headers: part.Headers}
+		var buf bytes.Buffer
+		n, err := io.CopyN(&buf, part, maxMemory+1)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n > maxMemory {
+			if err := fh.spill(&buf, part); err != nil {
+				return err
+			}
+		} else {
+			fh.content = buf.Bytes()
+			fh.Size = n
+			maxMemory -= n
+		}
+		f.File[name] = append(f.File[name], fh)
+	}
+}
+
+// spill writes the part read so far from buf, then the rest of it, to a
+// temporary file
+func (fh *FileHeader) spill(buf *bytes.Buffer, part *Part) error {
+	file, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return err
+	}
+	fh.tmpFile = file.Name()
+	size, err := io.Copy(file, io.MultiReader(buf, part))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fh.tmpFile)
+		return err
+	}
+	fh.Size = size
+	return nil
+}
@@ -0,0 +1,163 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lealre/httpfromtcp/internal/headers"
+)
+
+var ErrBodyNotConsumed = errors.New("previous request body not fully read")
+
+// Reader parses the successive requests sent on a connection. The bytes read
+// past the end of a request are kept for the next one, so pipelined requests
+// are not lost.
+type Reader struct {
+	reader      io.Reader
+	limits      Limits
+	slashes     EncodedSlashes
+	buf         []byte
+	readToIndex int
+	readErr     error
+	parseErr    error
+	current     *Request
+}
+
+func NewReader(reader io.Reader, limits Limits) *Reader {
+	return &Reader{
+		reader: reader,
+		limits: limits,
+		buf:    make([]byte, bufferSize),
+	}
+}
+
+// SetEncodedSlashes sets the policy for encoded slashes in the path of the
+// requests read next, DecodeSlashes by default
+func (cr *Reader) SetEncodedSlashes(policy EncodedSlashes) {
+	cr.slashes = policy
+}
+
+// ReadRequest parses the next request line and headers, see
+// RequestFromReader. The body of the previous request must have been read
+// to the end, otherwise ErrBodyNotConsumed is returned.
+func (cr *Reader) ReadRequest() (*Request, error) {
+	if cr.current != nil && cr.current.state != requestStateDone {
+		return nil, ErrBodyNotConsumed
+	}
+
+	req := &Request{
+		limits:   cr.limits,
+		slashes:  cr.slashes,
+		state:    requestStateInitialized,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		conn:     cr,
+	}
+	req.Body = &body{req: req}
+	cr.current = req
+	for req.state < requestStateParsingBody {
+		if err := req.readMore(); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// DiscardBody reads what is left of the body of the last request and throws
+// it away, whether or not its Body was closed or replaced. It fails with
+// ErrBodyNotConsumed if more than limit bytes are left.
+func (cr *Reader) DiscardBody(limit int) error {
+	if cr.current == nil {
+		return nil
+	}
+	return cr.current.discard(limit)
+}
+
+// discard parses the rest of the body, dropping the decoded bytes instead of
+// handing them out through Body
+func (r *Request) discard(limit int) error {
+	discarded := 0
+	for {
+		discarded += len(r.pending)
+		r.pending = nil
+		if discarded > limit {
+			return fmt.Errorf("%w: more than %d bytes left", ErrBodyNotConsumed, limit)
+		}
+		if r.state == requestStateDone {
+			return nil
+		}
+		if err := r.readMore(); err != nil {
+			return err
+		}
+	}
+}
+
+// readMore makes the parser progress, feeding it the data already buffered
+// or, when that is not enough, the next piece read from the connection
+func (r *Request) readMore() error {
+	cr := r.conn
+	if cr.parseErr != nil {
+		return cr.parseErr
+	}
+
+	progressed, err := r.parseBuffered()
+	if err != nil {
+		cr.parseErr = err
+		return err
+	}
+	if progressed {
+		return nil
+	}
+	if cr.readErr != nil {
+		return r.readError(cr.readErr)
+	}
+
+	if cr.readToIndex >= len(cr.buf) {
+		newBuf := make([]byte, len(cr.buf)*2)
+		copy(newBuf, cr.buf)
+		cr.buf = newBuf
+	}
+
+	numBytesRead, err := cr.reader.Read(cr.buf[cr.readToIndex:])
+	cr.readToIndex += numBytesRead
+	if err != nil {
+		// reported once the buffered data can't make the parser progress
+		cr.readErr = err
+	}
+	if numBytesRead == 0 && err != nil {
+		return r.readError(err)
+	}
+	return nil
+}
+
+// readError describes an error returned by the underlying reader for the
+// state the request is in
+func (r *Request) readError(err error) error {
+	if !errors.Is(err, io.EOF) {
+		return err
+	}
+	switch {
+	case r.state == requestStateInitialized && r.conn.readToIndex == 0:
+		// closed before sending anything, not a broken request
+		return io.EOF
+	case r.state < requestStateParsingBody:
+		return fmt.Errorf("%w, in state: %d", ErrIncompleteRequest, r.state)
+	default:
+		return fmt.Errorf("%w, in state: %d", ErrTruncatedBody, r.state)
+	}
+}
+
+// parseBuffered parses the buffered data and drops the consumed bytes from
+// the buffer. It reports whether the parser made any progress.
+func (r *Request) parseBuffered() (bool, error) {
+	cr := r.conn
+	prevState := r.state
+	numBytesParsed, err := r.parse(cr.buf[:cr.readToIndex])
+	if err != nil {
+		return false, err
+	}
+	copy(cr.buf, cr.buf[numBytesParsed:cr.readToIndex])
+	cr.readToIndex -= numBytesParsed
+	return numBytesParsed > 0 || r.state != prevState, nil
+}
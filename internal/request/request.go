@@ -13,10 +13,38 @@ import (
 
 type Request struct {
 	RequestLine RequestLine
-	Headers     headers.Headers
-	Body        []byte
+	// URL is the parsed request target of the request line
+	URL     *URL
+	Headers *headers.Headers
+	// Body streams the message body, decoding the Content-Length or chunked
+	// framing. It returns io.EOF once the whole body has been read.
+	Body io.ReadCloser
+	// Trailers holds the trailer fields sent after the last chunk of a
+	// chunked body. It is only complete once Body returned io.EOF.
+	Trailers *headers.Headers
+	// Form holds the query parameters merged with the ones of an urlencoded
+	// body, and PostForm the body ones alone. Both are nil until ParseForm
+	// is called.
+	Form     Values
+	PostForm Values
+	// MultipartForm holds the fields and files of a multipart/form-data
+	// body, nil until ParseMultipartForm is called
+	MultipartForm *MultipartForm
 
-	state requestState
+	limits         Limits
+	slashes        EncodedSlashes
+	state          requestState
+	headerBytes    int
+	headerCount    int
+	chunked        bool
+	contentLength  int
+	chunkRemaining int
+	bodyRead       int
+	// pending holds decoded body bytes not yet handed out by Body
+	pending []byte
+
+	conn       *Reader
+	pathValues map[string]string
 }
 
 type RequestLine struct {
@@ -31,48 +59,144 @@ const (
 	requestStateInitialized requestState = iota
 	requestStateParsingHeaders
 	requestStateParsingBody
+	requestStateParsingChunkSize
+	requestStateParsingChunkData
+	requestStateParsingTrailers
 	requestStateDone
 )
 
 const crlf = "\r\n"
-const bufferSize = 8
+const bufferSize = 4096
+
+// maxChunkLineBytes bounds a chunk-size line, extensions included
+const maxChunkLineBytes = 4096
+
+// Limits bounds how much data the parser accepts for each part of a request.
+// A zero value disables the corresponding limit.
+type Limits struct {
+	// MaxRequestLineBytes is the maximum length of the request line,
+	// without its CRLF
+	MaxRequestLineBytes int
+	// MaxHeaderBytes is the maximum number of bytes taken by the field
+	// lines, including the ones sent as trailers
+	MaxHeaderBytes int
+	// MaxHeaderCount is the maximum number of field lines
+	MaxHeaderCount int
+	// MaxBodyBytes is the maximum size of the decoded body
+	MaxBodyBytes int
+	// MaxFormBytes is the maximum size of a body parsed by ParseForm
+	MaxFormBytes int
+	// MaxMultipartBytes is the maximum size of a multipart body, parts
+	// headers and boundaries included
+	MaxMultipartBytes int
+	// MaxPartBytes is the maximum size of the content of a multipart part
+	MaxPartBytes int
+	// MaxParts is the maximum number of parts of a multipart body
+	MaxParts int
+}
+
+// DefaultLimits returns the limits used by RequestFromReader
+func DefaultLimits() Limits {
+	return Limits{
+		MaxRequestLineBytes: 8 * 1024,
+		MaxHeaderBytes:      1 << 20,
+		MaxHeaderCount:      100,
+		MaxFormBytes:        10 << 20,
+		MaxMultipartBytes:   100 << 20,
+		MaxPartBytes:        32 << 20,
+		MaxParts:            1000,
+	}
+}
+
+var (
+	ErrMalformedRequestLine = errors.New("malformed request line")
+	ErrInvalidMethod        = errors.New("invalid method")
+	ErrInvalidTarget        = errors.New("invalid request target")
+	ErrUnsupportedVersion   = errors.New("unsupported HTTP version")
+	ErrInvalidHeader        = errors.New("invalid header")
+	ErrBadContentLength     = errors.New("bad content-length")
+	ErrConflictingFraming   = errors.New("both transfer-encoding and content-length")
+	ErrBadTransferEncoding  = errors.New("bad transfer-encoding")
+	ErrUnsupportedCoding    = errors.New("unsupported transfer coding")
+	ErrMalformedChunk       = errors.New("malformed chunk")
+	ErrIncompleteRequest    = errors.New("incomplete request")
+	ErrTruncatedBody        = errors.New("truncated body")
 
+	ErrRequestLineTooLong = errors.New("request line too long")
+	ErrHeadersTooLarge    = errors.New("request header fields too large")
+	ErrBodyTooLarge       = errors.New("request body too large")
+)
+
+// RequestFromReader parses the request line and the headers from reader and
+// returns as soon as they are complete. The body is not read up front: it is
+// streamed from reader through Request.Body. It returns io.EOF if reader
+// ends before any byte of the request.
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
-	req := &Request{
-		state:   requestStateInitialized,
-		Headers: headers.NewHeaders(),
-		Body:    []byte{},
+	return RequestFromReaderWithLimits(reader, DefaultLimits())
+}
+
+// RequestFromReaderWithLimits works like RequestFromReader, failing with
+// ErrRequestLineTooLong, ErrHeadersTooLarge or ErrBodyTooLarge as soon as
+// the request goes over limits.
+func RequestFromReaderWithLimits(reader io.Reader, limits Limits) (*Request, error) {
+	return NewReader(reader, limits).ReadRequest()
+}
+
+// PathValue returns the value captured for the named wildcard of the route
+// pattern that matched the request, or "" if there is none
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+// SetPathValue sets the value returned by PathValue for name
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = map[string]string{}
 	}
-	for req.state != requestStateDone {
-		if readToIndex >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
-		}
+	r.pathValues[name] = value
+}
 
-		numBytesRead, err := reader.Read(buf[readToIndex:])
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				if req.state != requestStateDone {
-					return nil, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", req.state, numBytesRead)
-				}
-				break
-			}
-			return nil, err
-		}
-		readToIndex += numBytesRead
+// ReadBody reads the remaining body into memory and returns it. Body is
+// replaced by a reader over the returned bytes, so calling ReadBody again
+// returns the same data.
+func (r *Request) ReadBody() ([]byte, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
 
-		numBytesParsed, err := req.parse(buf[:readToIndex])
-		if err != nil {
-			return nil, err
-		}
+// body is the io.ReadCloser handed out as Request.Body
+type body struct {
+	req    *Request
+	closed bool
+}
 
-		copy(buf, buf[numBytesParsed:])
-		readToIndex -= numBytesParsed
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, fmt.Errorf("read on closed body")
 	}
-	return req, nil
+	r := b.req
+	for len(r.pending) == 0 {
+		if r.state == requestStateDone {
+			return 0, io.EOF
+		}
+		if err := r.readMore(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Close stops the body from being read any further. It does not close the
+// underlying reader.
+func (b *body) Close() error {
+	b.closed = true
+	return nil
 }
 
 func parseRequestLine(data []byte) (*RequestLine, int, error) {
@@ -91,30 +215,39 @@ func parseRequestLine(data []byte) (*RequestLine, int, error) {
 func requestLineFromString(str string) (*RequestLine, error) {
 	parts := strings.Split(str, " ")
 	if len(parts) != 3 {
-		return nil, fmt.Errorf("poorly formatted request-line: %s", str)
+		return nil, fmt.Errorf("%w: %s", ErrMalformedRequestLine, str)
 	}
 
 	method := parts[0]
+	if method == "" {
+		return nil, fmt.Errorf("%w: empty method", ErrInvalidMethod)
+	}
 	for _, c := range method {
 		if c < 'A' || c > 'Z' {
-			return nil, fmt.Errorf("invalid method: %s", method)
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMethod, method)
 		}
 	}
 
 	requestTarget := parts[1]
+	if requestTarget == "" {
+		return nil, fmt.Errorf("%w: empty request-target", ErrMalformedRequestLine)
+	}
 
 	versionParts := strings.Split(parts[2], "/")
 	if len(versionParts) != 2 {
-		return nil, fmt.Errorf("malformed start-line: %s", str)
+		return nil, fmt.Errorf("%w: %s", ErrMalformedRequestLine, str)
 	}
 
 	httpPart := versionParts[0]
 	if httpPart != "HTTP" {
-		return nil, fmt.Errorf("unrecognized HTTP-version: %s", httpPart)
+		return nil, fmt.Errorf("%w: unrecognized HTTP-name: %s", ErrMalformedRequestLine, httpPart)
 	}
 	version := versionParts[1]
+	if len(version) != 3 || !isDigit(version[0]) || version[1] != '.' || !isDigit(version[2]) {
+		return nil, fmt.Errorf("%w: malformed HTTP-version: %s", ErrMalformedRequestLine, version)
+	}
 	if version != "1.1" {
-		return nil, fmt.Errorf("unrecognized HTTP-version: %s", version)
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedVersion, version)
 	}
 
 	return &RequestLine{
@@ -127,6 +260,7 @@ func requestLineFromString(str string) (*RequestLine, error) {
 func (r *Request) parse(data []byte) (int, error) {
 	totalBytesParsed := 0
 	for r.state != requestStateDone {
+		prevState := r.state
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
 			return 0, err
@@ -135,6 +269,10 @@ func (r *Request) parse(data []byte) (int, error) {
 		if n == 0 {
 			break
 		}
+		if prevState == requestStateParsingHeaders && r.state != prevState {
+			// the body is only parsed when it is read
+			break
+		}
 	}
 	return totalBytesParsed, nil
 }
@@ -148,49 +286,246 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return 0, err
 		}
 		if n == 0 {
+			// leave room for the CR of a CRLF that is not complete yet
+			if r.limits.MaxRequestLineBytes > 0 && len(data) > r.limits.MaxRequestLineBytes+1 {
+				return 0, ErrRequestLineTooLong
+			}
 			// just need more data
 			return 0, nil
 		}
+		if exceeds(n-2, r.limits.MaxRequestLineBytes) {
+			return 0, ErrRequestLineTooLong
+		}
+		url, err := parseTarget(requestLine.Method, requestLine.RequestTarget, r.slashes)
+		if err != nil {
+			return 0, err
+		}
 		r.RequestLine = *requestLine
+		r.URL = url
 		r.state = requestStateParsingHeaders
 		return n, nil
 	case requestStateParsingHeaders:
-		n, done, err := r.Headers.Parse(data)
+		n, done, err := r.parseFieldLine(r.Headers, data)
 		if err != nil {
 			return 0, err
 		}
 		if done {
+			if err := r.parseFraming(); err != nil {
+				return 0, err
+			}
 			r.state = requestStateParsingBody
 		}
 		return n, nil
 	case requestStateParsingBody:
-		contentLength := r.Headers.Get("content-length")
-		if contentLength == "" {
+		if r.chunked {
+			r.state = requestStateParsingChunkSize
+			return r.parseSingle(data)
+		}
+
+		n := min(len(data), r.contentLength-r.bodyRead)
+		r.pending = append(r.pending, data[:n]...)
+		r.bodyRead += n
+
+		if r.bodyRead == r.contentLength {
 			r.state = requestStateDone
+		}
+		return n, nil
+	case requestStateParsingChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx > maxChunkLineBytes || idx == -1 && len(data) > maxChunkLineBytes {
+			return 0, fmt.Errorf("%w: chunk-size line too long", ErrMalformedChunk)
+		}
+		if idx == -1 {
 			return 0, nil
 		}
-
-		contentLengthAsnumber, err := strconv.Atoi(contentLength)
+		chunkSize, err := parseChunkSize(data[:idx])
 		if err != nil {
-			return 0, fmt.Errorf("error converting content-length to integer")
+			return 0, err
 		}
-
-		r.Body = append(r.Body, data...)
-
-		if contentLengthAsnumber < len(r.Body) {
-			return 0, fmt.Errorf("content-length header values is bigger than the actual body")
+		if chunkSize == 0 {
+			r.state = requestStateParsingTrailers
+		} else {
+			r.chunkRemaining = chunkSize
+			r.state = requestStateParsingChunkData
+		}
+		return idx + 2, nil
+	case requestStateParsingChunkData:
+		if r.chunkRemaining == 0 {
+			// the whole chunk was read, it must be followed by a CRLF
+			if len(data) < 2 {
+				return 0, nil
+			}
+			if !bytes.HasPrefix(data, []byte(crlf)) {
+				return 0, fmt.Errorf("%w: missing CRLF after chunk data", ErrMalformedChunk)
+			}
+			r.state = requestStateParsingChunkSize
+			return 2, nil
 		}
 
-		if contentLengthAsnumber == len(r.Body) {
+		n := min(len(data), r.chunkRemaining)
+		if exceeds(r.bodyRead+n, r.limits.MaxBodyBytes) {
+			return 0, ErrBodyTooLarge
+		}
+		r.bodyRead += n
+		r.pending = append(r.pending, data[:n]...)
+		r.chunkRemaining -= n
+		return n, nil
+	case requestStateParsingTrailers:
+		n, done, err := r.parseFieldLine(r.Trailers, data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
 			r.state = requestStateDone
-			return len(data), nil
 		}
-
-		return len(data), nil
-
+		return n, nil
 	case requestStateDone:
 		return 0, fmt.Errorf("error: trying to read data in a done state")
 	default:
 		return 0, fmt.Errorf("unknown state")
 	}
 }
+
+// parseFieldLine parses a single header or trailer field line into h while
+// keeping track of the header limits
+func (r *Request) parseFieldLine(h *headers.Headers, data []byte) (int, bool, error) {
+	n, done, err := h.Parse(data)
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	if n == 0 {
+		if exceeds(r.headerBytes+len(data), r.limits.MaxHeaderBytes) {
+			return 0, false, ErrHeadersTooLarge
+		}
+		return 0, false, nil
+	}
+
+	r.headerBytes += n
+	if exceeds(r.headerBytes, r.limits.MaxHeaderBytes) {
+		return 0, false, ErrHeadersTooLarge
+	}
+	if !done {
+		r.headerCount++
+		if exceeds(r.headerCount, r.limits.MaxHeaderCount) {
+			return 0, false, ErrHeadersTooLarge
+		}
+	}
+	return n, done, nil
+}
+
+// parseFraming finds how the body is delimited, following RFC 9112
+// section 6.3. Requests with ambiguous framing, which could be read
+// differently by another server in the chain, are rejected. A declared body
+// bigger than the limit is rejected up front.
+func (r *Request) parseFraming() error {
+	transferEncodings := r.Headers.Values("transfer-encoding")
+	contentLengths := r.Headers.Values("content-length")
+
+	if len(transferEncodings) > 0 {
+		if len(contentLengths) > 0 {
+			return ErrConflictingFraming
+		}
+		codings := []string{}
+		for _, value := range transferEncodings {
+			for _, coding := range strings.Split(value, ",") {
+				codings = append(codings, strings.ToLower(strings.TrimSpace(coding)))
+			}
+		}
+		if codings[len(codings)-1] != "chunked" {
+			return fmt.Errorf("%w: chunked must be the final transfer coding", ErrBadTransferEncoding)
+		}
+		for _, coding := range codings[:len(codings)-1] {
+			switch coding {
+			case "":
+				return fmt.Errorf("%w: empty transfer coding", ErrBadTransferEncoding)
+			case "chunked":
+				return fmt.Errorf("%w: chunked applied more than once", ErrBadTransferEncoding)
+			default:
+				return fmt.Errorf("%w: %s", ErrUnsupportedCoding, coding)
+			}
+		}
+		r.chunked = true
+		return nil
+	}
+
+	r.contentLength = 0
+	if len(contentLengths) == 0 {
+		return nil
+	}
+	contentLength := -1
+	for _, value := range contentLengths {
+		for _, part := range strings.Split(value, ",") {
+			n, err := parseContentLength(strings.TrimSpace(part))
+			if err != nil {
+				return err
+			}
+			if contentLength != -1 && n != contentLength {
+				return fmt.Errorf("%w: differing values %s", ErrBadContentLength, r.Headers.Get("content-length"))
+			}
+			contentLength = n
+		}
+	}
+	if exceeds(contentLength, r.limits.MaxBodyBytes) {
+		return ErrBodyTooLarge
+	}
+	r.contentLength = contentLength
+	return nil
+}
+
+// parseContentLength parses a single content-length value, which can only
+// be made of digits
+func parseContentLength(value string) (int, error) {
+	if value == "" {
+		return 0, fmt.Errorf("%w: empty value", ErrBadContentLength)
+	}
+	for i := 0; i < len(value); i++ {
+		if !isDigit(value[i]) {
+			return 0, fmt.Errorf("%w: %s", ErrBadContentLength, value)
+		}
+	}
+	contentLength, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrBadContentLength, value)
+	}
+	return contentLength, nil
+}
+
+// exceeds reports whether n goes over limit, a zero limit meaning no limit
+func exceeds(n, limit int) bool {
+	return limit > 0 && n > limit
+}
+
+// parseChunkSize parses a chunk-size line, ignoring any chunk extensions:
+//
+//	chunk-size [ chunk-ext ] CRLF
+//
+// Whitespace is only allowed between the size and the extensions.
+func parseChunkSize(line []byte) (int, error) {
+	sizePart, _, hasExt := bytes.Cut(line, []byte(";"))
+	if hasExt {
+		sizePart = bytes.TrimRight(sizePart, " \t")
+	}
+	if len(sizePart) == 0 {
+		return 0, fmt.Errorf("%w: missing chunk size", ErrMalformedChunk)
+	}
+	for _, c := range sizePart {
+		if !isHexDigit(c) {
+			return 0, fmt.Errorf("%w: invalid chunk size: %s", ErrMalformedChunk, sizePart)
+		}
+	}
+	chunkSize, err := strconv.ParseInt(string(sizePart), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid chunk size: %s", ErrMalformedChunk, sizePart)
+	}
+	return int(chunkSize), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' ||
+		c >= 'a' && c <= 'f' ||
+		c >= 'A' && c <= 'F'
+}
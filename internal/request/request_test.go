@@ -1,7 +1,12 @@
 package request
 
 import (
+	"fmt"
 	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -79,9 +84,9 @@ func TestHeadersParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "curl/7.81.0", r.Headers.Get("user-agent"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
 
 	// Test: Empty Headers
 	reader = &chunkReader{
@@ -91,7 +96,7 @@ func TestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Empty(t, r.Headers)
+	assert.Equal(t, 0, r.Headers.Len())
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -109,7 +114,7 @@ func TestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, duplicate:8080", r.Headers["host"])
+	assert.Equal(t, "localhost:42069, duplicate:8080", r.Headers.Get("host"))
 
 	// Test: Case Insensitive Headers
 	reader = &chunkReader{
@@ -119,8 +124,8 @@ func TestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "curl/7.81.0", r.Headers.Get("user-agent"))
 
 	// Test: Missing End of Headers
 	reader = &chunkReader{
@@ -144,7 +149,36 @@ func TestBodyParser(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+
+	// Test: Empty Body, 0 reported content length
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	// Test: Empty Body, no reported content length
+	reader = &chunkReader{
+		data: "GET / HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Empty(t, body)
 
 	// Test: Body shorter than reported content length
 	reader = &chunkReader{
@@ -155,15 +189,492 @@ func TestBodyParser(t *testing.T) {
 			"partial content",
 		numBytesPerRead: 3,
 	}
-	_, err = RequestFromReader(reader)
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+
+	// Test: Body is streamed in small reads
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 64,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	p := make([]byte, 5)
+	n, err := r.Body.Read(p)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(p[:n]))
+	rest, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, " world!\n", string(rest))
+
+	// Test: Body stops at content length
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 64,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Closed body can't be read
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	_, err = r.Body.Read(p)
+	require.Error(t, err)
+}
+
+func TestChunkedBodyParser(t *testing.T) {
+	// Test: Standard chunked body
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"7\r\n world!\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(body))
+	assert.Equal(t, 0, r.Trailers.Len())
+
+	// Test: Chunk extensions and hex sizes
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"a;name=value\r\n0123456789\r\n" +
+			"0;last\r\n" +
+			"\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(body))
+
+	// Test: Trailers after the last chunk
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"4\r\ndata\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(body))
+	assert.Equal(t, "abc123", r.Trailers.Get("x-checksum"))
+	assert.Empty(t, r.Headers.Get("x-checksum"))
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\nhello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
 	require.Error(t, err)
 
-	// TODO: Add these tests
-	//	"Standard Body" (valid)
-	// "Empty Body, 0 reported content length" (valid)
-	// "Empty Body, no reported content length" (valid)
-	// "Body shorter than reported content length" (should error)
-	// "No Content-Length but Body Exists" (shouldn't error, we're assuming Content-Length will be present if a body exists)
+	// Test: Chunk data longer than its size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nhello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+
+	// Test: Missing last chunk
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+
+	// Test: Whitespace is only allowed before the extensions
+	chunked := "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n%s\r\nhello\r\n0\r\n\r\n"
+	for _, line := range []string{" 5", "5 ", "5\t", " 5;ext"} {
+		reader = &chunkReader{data: fmt.Sprintf(chunked, line), numBytesPerRead: 3}
+		r, err = RequestFromReader(reader)
+		require.NoError(t, err)
+		_, err = r.ReadBody()
+		assert.ErrorIs(t, err, ErrMalformedChunk, line)
+	}
+	reader = &chunkReader{data: fmt.Sprintf(chunked, "5 \t;ext=1"), numBytesPerRead: 3}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Endless chunk-size line
+	r, err = RequestFromReader(io.MultiReader(
+		strings.NewReader("POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5;"),
+		endlessReader{},
+	))
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, ErrMalformedChunk)
+}
+
+// endlessReader returns "a" bytes forever
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	return len(p), nil
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"Missing request line part", "/coffee HTTP/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"Lowercase method", "get /coffee HTTP/1.1\r\n\r\n", ErrInvalidMethod},
+		{"Unknown HTTP-name", "GET /coffee TCP/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"Malformed HTTP-version", "GET /coffee HTTP/one\r\n\r\n", ErrMalformedRequestLine},
+		{"Unsupported HTTP-version", "GET /coffee HTTP/2.0\r\n\r\n", ErrUnsupportedVersion},
+		{"Invalid header token", "GET / HTTP/1.1\r\nH\u00e9st: localhost\r\n\r\n", ErrInvalidHeader},
+		{"Header without colon", "GET / HTTP/1.1\r\nHost\r\n\r\n", ErrInvalidHeader},
+		{"Non numeric content-length", "POST / HTTP/1.1\r\nContent-Length: ten\r\n\r\n", ErrBadContentLength},
+		{"Negative content-length", "POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n", ErrBadContentLength},
+		{"Connection closed in headers", "GET / HTTP/1.1\r\nHost: localhost", ErrIncompleteRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &chunkReader{
+				data:            tc.data,
+				numBytesPerRead: 3,
+			}
+			_, err := RequestFromReader(reader)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	// Test: Body errors are reported when reading the body
+	reader := &chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nhello",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.ErrorIs(t, err, ErrTruncatedBody)
+
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nxyz\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.ErrorIs(t, err, ErrMalformedChunk)
+}
+
+func TestFramingErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"Differing duplicate content-length", "POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 10\r\n\r\n", ErrBadContentLength},
+		{"Differing content-length list", "POST / HTTP/1.1\r\nContent-Length: 5, 10\r\n\r\n", ErrBadContentLength},
+		{"Signed content-length", "POST / HTTP/1.1\r\nContent-Length: +5\r\n\r\n", ErrBadContentLength},
+		{"Empty content-length", "POST / HTTP/1.1\r\nContent-Length: \r\n\r\n", ErrBadContentLength},
+		{"Overflowing content-length", "POST / HTTP/1.1\r\nContent-Length: 99999999999999999999999\r\n\r\n", ErrBadContentLength},
+		{"Transfer-encoding and content-length", "POST / HTTP/1.1\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n", ErrConflictingFraming},
+		{"Unknown transfer coding", "POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n", ErrUnsupportedCoding},
+		{"Chunked not last", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked, gzip\r\n\r\n", ErrBadTransferEncoding},
+		{"Chunked twice", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n", ErrBadTransferEncoding},
+		{"Transfer-encoding after leading whitespace", "POST / HTTP/1.1\r\nX-Pad: a\r\n Transfer-Encoding: chunked\r\n\r\n0\r\n\r\n", ErrInvalidHeader},
+		{"Folded line", "POST / HTTP/1.1\r\nX-Pad: a\r\n\tb\r\n\r\n", ErrInvalidHeader},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &chunkReader{
+				data:            tc.data,
+				numBytesPerRead: 3,
+			}
+			_, err := RequestFromReader(reader)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	// Test: Identical duplicate content-length values are accepted
+	reader := &chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 5, 5\r\n\r\nhello",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Transfer coding names are case-insensitive
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nTransfer-Encoding: Chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(body))
+}
+
+func TestReaderPipelining(t *testing.T) {
+	// Test: Pipelined requests in a single read
+	reader := &chunkReader{
+		data: "GET /first HTTP/1.1\r\nHost: localhost:42069\r\n\r\n" +
+			"POST /second HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello" +
+			"POST /third HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n" +
+			"GET /fourth HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 1024,
+	}
+	conn := NewReader(reader, DefaultLimits())
+
+	r, err := conn.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	r, err = conn.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	r, err = conn.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/third", r.RequestLine.RequestTarget)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(body))
+
+	r, err = conn.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/fourth", r.RequestLine.RequestTarget)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+
+	_, err = conn.ReadRequest()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Pipelined requests in small reads
+	reader = &chunkReader{
+		data: "POST /first HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc" +
+			"GET /second HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 2,
+	}
+	conn = NewReader(reader, DefaultLimits())
+	r, err = conn.ReadRequest()
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(body))
+	r, err = conn.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+
+	// Test: Next request before the body was read
+	reader = &chunkReader{
+		data: "POST /first HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc" +
+			"GET /second HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 1024,
+	}
+	conn = NewReader(reader, DefaultLimits())
+	_, err = conn.ReadRequest()
+	require.NoError(t, err)
+	_, err = conn.ReadRequest()
+	require.ErrorIs(t, err, ErrBodyNotConsumed)
+
+	// Test: Body discarded after being closed
+	reader = &chunkReader{
+		data: "POST /first HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n2\r\nde\r\n0\r\n\r\n" +
+			"GET /second HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 2,
+	}
+	conn = NewReader(reader, DefaultLimits())
+	r, err = conn.ReadRequest()
+	require.NoError(t, err)
+	r.Body.Close()
+	require.NoError(t, conn.DiscardBody(5))
+	r, err = conn.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+
+	// Test: Body too big to be discarded
+	reader = &chunkReader{
+		data:            "POST /first HTTP/1.1\r\nContent-Length: 6\r\n\r\nabcdef",
+		numBytesPerRead: 2,
+	}
+	conn = NewReader(reader, DefaultLimits())
+	_, err = conn.ReadRequest()
+	require.NoError(t, err)
+	require.ErrorIs(t, conn.DiscardBody(5), ErrBodyNotConsumed)
+
+	// Test: Connection closed in the middle of the second request
+	reader = &chunkReader{
+		data: "GET /first HTTP/1.1\r\n\r\n" +
+			"GET /second HTTP/1.1\r\nHost",
+		numBytesPerRead: 1024,
+	}
+	conn = NewReader(reader, DefaultLimits())
+	r, err = conn.ReadRequest()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	_, err = conn.ReadRequest()
+	require.ErrorIs(t, err, ErrIncompleteRequest)
+}
+
+func TestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineBytes: 24,
+		MaxHeaderBytes:      64,
+		MaxHeaderCount:      3,
+		MaxBodyBytes:        10,
+	}
+
+	// Test: Request within limits
+	reader := &chunkReader{
+		data: "POST /coffee HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReaderWithLimits(reader, limits)
+	require.NoError(t, err)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Request line too long
+	reader = &chunkReader{
+		data:            "GET /a/very/long/request/target HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReaderWithLimits(reader, limits)
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Request line too long without a CRLF
+	reader = &chunkReader{
+		data:            "GET /a/very/long/request/target/without/end",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReaderWithLimits(reader, limits)
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Too many header bytes
+	reader = &chunkReader{
+		data: "GET / HTTP/1.1\r\n" +
+			"X-Long: aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReaderWithLimits(reader, limits)
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Too many header lines
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReaderWithLimits(reader, limits)
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Declared content length too large
+	reader = &chunkReader{
+		data: "POST / HTTP/1.1\r\n" +
+			"Content-Length: 11\r\n" +
+			"\r\n" +
+			"hello world",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReaderWithLimits(reader, limits)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunked body too large
+	reader = &chunkReader{
+		data: "POST / HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n" +
+			"6\r\nworld!\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReaderWithLimits(reader, limits)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.ErrorIs(t, err, ErrBodyTooLarge)
 }
 
 type chunkReader struct {
@@ -188,3 +699,302 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 	}
 	return n, nil
 }
+
+func TestRequestTarget(t *testing.T) {
+	// Test: Origin form with a query
+	reader := &chunkReader{
+		data:            "GET /caf%C3%A9/menu?item=tea&item=coffee&q=a+b%26c&flag HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, OriginForm, r.URL.Form)
+	assert.Equal(t, "/café/menu", r.URL.Path)
+	assert.Equal(t, "/caf%C3%A9/menu", r.URL.RawPath)
+	assert.Equal(t, "item=tea&item=coffee&q=a+b%26c&flag", r.URL.RawQuery)
+	assert.Equal(t, []string{"tea", "coffee"}, r.URL.Query["item"])
+	assert.Equal(t, "tea", r.URL.Query.Get("item"))
+	assert.Equal(t, "a b&c", r.URL.Query.Get("q"))
+	assert.True(t, r.URL.Query.Has("flag"))
+	assert.False(t, r.URL.Query.Has("missing"))
+
+	// Test: Absolute form
+	u, err := ParseTarget("GET", "HTTP://example.com:8080?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, AbsoluteForm, u.Form)
+	assert.Equal(t, "http", u.Scheme)
+	assert.Equal(t, "example.com:8080", u.Host)
+	assert.Equal(t, "/", u.Path)
+	assert.Equal(t, "1", u.Query.Get("x"))
+
+	// Test: Authority form
+	u, err = ParseTarget("CONNECT", "[::1]:443")
+	require.NoError(t, err)
+	assert.Equal(t, AuthorityForm, u.Form)
+	assert.Equal(t, "[::1]:443", u.Host)
+	assert.Equal(t, "", u.Path)
+
+	// Test: Asterisk form
+	u, err = ParseTarget("OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, AsteriskForm, u.Form)
+
+	// Test: Invalid targets
+	invalid := []struct{ method, target string }{
+		{"GET", "*"},
+		{"GET", "example.com:443"},
+		{"CONNECT", "/path"},
+		{"CONNECT", "example.com"},
+		{"CONNECT", "example.com:"},
+		{"GET", "/path#fragment"},
+		{"GET", "/bad%2"},
+		{"GET", "/bad%zz"},
+		{"GET", "/ok?q=%g0"},
+		{"GET", "/café"},
+		{"GET", "ftp://example.com/"},
+		{"GET", "http:///path"},
+		{"GET", "http://user@example.com/"},
+	}
+	for _, tc := range invalid {
+		_, err := ParseTarget(tc.method, tc.target)
+		assert.ErrorIs(t, err, ErrInvalidTarget, "%s %s", tc.method, tc.target)
+	}
+
+	reader = &chunkReader{
+		data:            "GET /bad%zz HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct{ target, clean string }{
+		{"/", "/"},
+		{"/a/b/c", "/a/b/c"},
+		{"/a/./b/../c", "/a/c"},
+		{"/a/b/..", "/a/"},
+		{"/a//b/", "/a/b/"},
+		{"//a", "/a"},
+		{"/../../etc/passwd", "/etc/passwd"},
+		{"/%2e%2e/%2E%2E/etc/passwd", "/etc/passwd"},
+		{"/static/..%2f..%2fetc/passwd", "/etc/passwd"},
+		{"/a/.../b", "/a/.../b"},
+	}
+	for _, tc := range tests {
+		u, err := ParseTarget("GET", tc.target)
+		require.NoError(t, err)
+		assert.Equal(t, tc.clean, u.CleanPath, tc.target)
+	}
+
+	// Test: Control characters decoded from the path are rejected
+	for _, target := range []string{"/a%00b", "/a%0d%0aSet-Cookie:%20x", "/a%7F", "/%09/b"} {
+		_, err := ParseTarget("GET", target)
+		assert.ErrorIs(t, err, ErrInvalidTarget, target)
+	}
+
+	u, err := ParseTarget("GET", "/a/../b%20c?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, "/b%20c?x=1", u.CanonicalTarget())
+
+	// Test: Encoded slashes kept in their segment
+	reader := NewReader(&chunkReader{
+		data:            "GET /files/..%2f..%2Fetc%25/x HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}, DefaultLimits())
+	reader.SetEncodedSlashes(KeepSlashes)
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/files/..%2F..%2Fetc%25/x", r.URL.Path)
+	assert.Equal(t, "/files/..%2F..%2Fetc%25/x", r.URL.CleanPath)
+
+	u, err = parseTarget("GET", "/a/b/../%2F", KeepSlashes)
+	require.NoError(t, err)
+	assert.Equal(t, "/a/%2F", u.CanonicalTarget())
+
+	// Test: Encoded slashes rejected
+	reader = NewReader(&chunkReader{
+		data:            "GET /files/a%2Fb HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}, DefaultLimits())
+	reader.SetEncodedSlashes(RejectSlashes)
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+}
+
+func TestParseForm(t *testing.T) {
+	parse := func(data string, limits Limits) (*Request, error) {
+		reader := &chunkReader{data: data, numBytesPerRead: 3}
+		r, err := RequestFromReaderWithLimits(reader, limits)
+		require.NoError(t, err)
+		return r, r.ParseForm()
+	}
+
+	// Test: Body merged with the query, body values first
+	body := "name=Ada+Lovelace&lang=en&lang=fr&empty="
+	r, err := parse("POST /submit?lang=de&page=2 HTTP/1.1\r\n"+
+		"Content-Type: application/x-www-form-urlencoded; charset=UTF-8\r\n"+
+		"Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+body, DefaultLimits())
+	require.NoError(t, err)
+	assert.Equal(t, "Ada Lovelace", r.Form.Get("name"))
+	assert.Equal(t, []string{"en", "fr", "de"}, r.Form["lang"])
+	assert.Equal(t, []string{"en", "fr"}, r.PostForm["lang"])
+	assert.Equal(t, "2", r.Form.Get("page"))
+	assert.False(t, r.PostForm.Has("page"))
+	assert.True(t, r.Form.Has("empty"))
+	assert.Equal(t, "Ada Lovelace", r.FormValue("name"))
+
+	// Test: Other content types leave the body alone
+	r, err = parse("POST /submit?q=1 HTTP/1.1\r\nContent-Type: application/json\r\n"+
+		"Content-Length: 7\r\n\r\na=b&c=d", DefaultLimits())
+	require.NoError(t, err)
+	assert.Equal(t, Values{"q": {"1"}}, r.Form)
+	assert.Empty(t, r.PostForm)
+	data, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "a=b&c=d", string(data))
+
+	// Test: GET only has the query
+	r, err = parse("GET /?a=1 HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded\r\n\r\n", DefaultLimits())
+	require.NoError(t, err)
+	assert.Equal(t, Values{"a": {"1"}}, r.Form)
+
+	// Test: ISO-8859-1 body
+	r, err = parse("POST / HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded; charset=\"ISO-8859-1\"\r\n"+
+		"Content-Length: 10\r\n\r\ncity=Z%FCr", DefaultLimits())
+	require.NoError(t, err)
+	assert.Equal(t, "Zür", r.Form.Get("city"))
+
+	// Test: Errors
+	form := "POST /?q=1 HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded%s\r\nContent-Length: %d\r\n\r\n%s"
+	r, err = parse(fmt.Sprintf(form, "; charset=utf-16", 3, "a=b"), DefaultLimits())
+	assert.ErrorIs(t, err, ErrUnsupportedCharset)
+	assert.Equal(t, "1", r.Form.Get("q"))
+	_, err = parse(fmt.Sprintf(form, "", 5, "a=%zz"), DefaultLimits())
+	assert.ErrorIs(t, err, ErrInvalidForm)
+	_, err = parse(fmt.Sprintf(form, "", 5, "a=%FF"), DefaultLimits())
+	assert.ErrorIs(t, err, ErrInvalidForm)
+	limits := DefaultLimits()
+	limits.MaxFormBytes = 8
+	_, err = parse(fmt.Sprintf(form, "", 9, "a=1&b=234"), limits)
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+	_, err = parse(fmt.Sprintf(form, "", 8, "a=1&b=23"), limits)
+	assert.NoError(t, err)
+}
+
+func TestMultipart(t *testing.T) {
+	request := func(body string) string {
+		return "POST /upload?q=1 HTTP/1.1\r\n" +
+			"Content-Type: multipart/form-data; boundary=\"xYz\"\r\n" +
+			"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	}
+	parse := func(data string, limits Limits) *Request {
+		reader := &chunkReader{data: data, numBytesPerRead: 5}
+		r, err := RequestFromReaderWithLimits(reader, limits)
+		require.NoError(t, err)
+		return r
+	}
+	body := "preamble\r\n" +
+		"--xYz\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+		"Hello\r\nworld\r\n" +
+		"--xYz  \r\n" +
+		"Content-Disposition: form-data; name=\"doc\"; filename=\"C:\\tmp\\notes.txt\"\r\n" +
+		"Content-Type: text/plain\r\n\r\n" +
+		"--xY not a boundary\r\n" + strings.Repeat("a", 5000) + "\r\n" +
+		"--xYz\r\n" +
+		"Content-Disposition: form-data; name=\"doc\"; filename=\"small.txt\"\r\n\r\n" +
+		"tiny\r\n" +
+		"--xYz--\r\nepilogue"
+
+	// Test: Streamed parts
+	r := parse(request(body), DefaultLimits())
+	mr, err := r.MultipartReader()
+	require.NoError(t, err)
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName())
+	assert.Equal(t, "", part.FileName())
+	data, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello\r\nworld", string(data))
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "notes.txt", part.FileName())
+	assert.Equal(t, "text/plain", part.Headers.Get("Content-Type"))
+	// the rest of an unread part is skipped
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "small.txt", part.FileName())
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Whole form, big files spilled to disk
+	r = parse(request(body), DefaultLimits())
+	require.NoError(t, r.ParseMultipartForm(1024))
+	form := r.MultipartForm
+	assert.Equal(t, Values{"title": {"Hello\r\nworld"}}, form.Value)
+	assert.Equal(t, []string{"Hello\r\nworld"}, r.PostForm["title"])
+	assert.Equal(t, "1", r.Form.Get("q"))
+	require.Len(t, form.File["doc"], 2)
+	big, small := form.File["doc"][0], form.File["doc"][1]
+	assert.Equal(t, int64(5021), big.Size)
+	assert.NotEmpty(t, big.tmpFile)
+	assert.Empty(t, small.tmpFile)
+	file, err := big.Open()
+	require.NoError(t, err)
+	data, err = io.ReadAll(file)
+	require.NoError(t, err)
+	file.Close()
+	assert.Equal(t, "--xY not a boundary\r\n"+strings.Repeat("a", 5000), string(data))
+	file, err = small.Open()
+	require.NoError(t, err)
+	data, err = io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "tiny", string(data))
+	require.NoError(t, form.RemoveAll())
+	_, err = os.Stat(big.tmpFile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Test: Close delimiter without its CRLF
+	r = parse(request("--xYz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\r\n--xYz--"), DefaultLimits())
+	require.NoError(t, r.ParseMultipartForm(1024))
+	assert.Equal(t, "1", r.PostForm.Get("a"))
+
+	// Test: Values count against the memory budget
+	r = parse(request(body), DefaultLimits())
+	assert.ErrorIs(t, r.ParseMultipartForm(11), ErrMultipartTooLarge)
+	r = parse(request(body), DefaultLimits())
+	require.NoError(t, r.ParseMultipartForm(12))
+	assert.NotEmpty(t, r.MultipartForm.File["doc"][1].tmpFile)
+	require.NoError(t, r.MultipartForm.RemoveAll())
+
+	// Test: Unbounded memory budget keeps everything in memory
+	r = parse(request(body), DefaultLimits())
+	require.NoError(t, r.ParseMultipartForm(math.MaxInt64))
+	assert.Equal(t, []string{"Hello\r\nworld"}, r.PostForm["title"])
+	for _, fh := range r.MultipartForm.File["doc"] {
+		assert.Empty(t, fh.tmpFile)
+	}
+	assert.Equal(t, int64(5000+len("--xY not a boundary\r\n")), r.MultipartForm.File["doc"][0].Size)
+
+	// Test: Errors
+	r = parse("POST / HTTP/1.1\r\nContent-Type: multipart/form-data\r\nContent-Length: 0\r\n\r\n", DefaultLimits())
+	assert.ErrorIs(t, r.ParseMultipartForm(1024), ErrNotMultipart)
+	r = parse(request("--xYz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\ntruncated"), DefaultLimits())
+	assert.ErrorIs(t, r.ParseMultipartForm(1024), ErrMalformedMultipart)
+	r = parse(request("--xYz garbage\r\n\r\n--xYz--\r\n"), DefaultLimits())
+	assert.ErrorIs(t, r.ParseMultipartForm(1024), ErrMalformedMultipart)
+	limits := DefaultLimits()
+	limits.MaxPartBytes = 100
+	r = parse(request(body), limits)
+	assert.ErrorIs(t, r.ParseMultipartForm(1024), ErrPartTooLarge)
+	limits = DefaultLimits()
+	limits.MaxMultipartBytes = 1000
+	r = parse(request(body), limits)
+	assert.ErrorIs(t, r.ParseMultipartForm(1024), ErrMultipartTooLarge)
+	limits = DefaultLimits()
+	limits.MaxParts = 2
+	r = parse(request(body), limits)
+	assert.ErrorIs(t, r.ParseMultipartForm(1024), ErrTooManyParts)
+}
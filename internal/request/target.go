@@ -0,0 +1,323 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TargetForm is one of the forms of request target defined in RFC 9112
+// section 3.2
+type TargetForm int
+
+const (
+	// OriginForm is an absolute path with an optional query, like
+	// /search?q=go
+	OriginForm TargetForm = iota
+	// AbsoluteForm is a whole URI, like http://example.com/search?q=go,
+	// mostly sent to proxies
+	AbsoluteForm
+	// AuthorityForm is a host and a port, like example.com:443, only used
+	// by CONNECT
+	AuthorityForm
+	// AsteriskForm is "*", only used by OPTIONS for the whole server
+	AsteriskForm
+)
+
+// EncodedSlashes is the policy for percent-encoded slashes, %2F, in the
+// path of a request target
+type EncodedSlashes int
+
+const (
+	// DecodeSlashes decodes %2F into a "/" that separates segments like
+	// any other
+	DecodeSlashes EncodedSlashes = iota
+	// KeepSlashes leaves %2F encoded in the path, as part of its segment.
+	// %25 is left encoded too, so "%2F" in the path can only come from an
+	// encoded slash.
+	KeepSlashes
+	// RejectSlashes makes targets with %2F in their path invalid
+	RejectSlashes
+)
+
+// URL is the parsed request target
+type URL struct {
+	Form TargetForm
+	// Scheme is set for the absolute form, in lower case
+	Scheme string
+	// Host is the authority of the absolute and authority forms
+	Host string
+	// Path is the percent-decoded path and RawPath the path as sent. Both
+	// are "/" for an absolute form without a path, and empty for the
+	// authority and asterisk forms.
+	Path    string
+	RawPath string
+	// CleanPath is Path in its canonical form: "." and ".." segments are
+	// resolved, never going above the root, and repeated slashes merged.
+	// Routing and mapping paths to files should rely on it.
+	CleanPath string
+	// RawQuery is the query as sent, without the "?"
+	RawQuery string
+	// Query holds the decoded query parameters
+	Query Values
+
+	slashes EncodedSlashes
+}
+
+// Values maps parameter names to their values, in the order they were sent
+type Values map[string][]string
+
+// Get returns the first value of key, or "" if there is none
+func (v Values) Get(key string) string {
+	if values := v[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Has reports whether key has at least one value
+func (v Values) Has(key string) bool {
+	_, ok := v[key]
+	return ok
+}
+
+// Add appends value to the values of key
+func (v Values) Add(key, value string) {
+	v[key] = append(v[key], value)
+}
+
+// ParseTarget parses the request target of a request with method, decoding
+// encoded slashes. It fails with ErrInvalidTarget for malformed targets and
+// for forms that method can't use: CONNECT only takes the authority form,
+// which no other method can use, and only OPTIONS can use the asterisk form.
+func ParseTarget(method, target string) (*URL, error) {
+	return parseTarget(method, target, DecodeSlashes)
+}
+
+func parseTarget(method, target string, slashes EncodedSlashes) (*URL, error) {
+	for i := 0; i < len(target); i++ {
+		c := target[i]
+		if c <= ' ' || c >= 0x7f || c == '#' {
+			return nil, fmt.Errorf("%w: invalid character %q in %q", ErrInvalidTarget, c, target)
+		}
+	}
+
+	switch {
+	case method == "CONNECT":
+		return parseAuthorityForm(target)
+	case target == "*":
+		if method != "OPTIONS" {
+			return nil, fmt.Errorf("%w: * is only allowed with OPTIONS", ErrInvalidTarget)
+		}
+		return &URL{Form: AsteriskForm, Query: Values{}}, nil
+	case strings.HasPrefix(target, "/"):
+		u := &URL{Form: OriginForm, slashes: slashes}
+		if err := u.parsePathAndQuery(target); err != nil {
+			return nil, err
+		}
+		return u, nil
+	default:
+		return parseAbsoluteForm(target, slashes)
+	}
+}
+
+func parseAbsoluteForm(target string, slashes EncodedSlashes) (*URL, error) {
+	scheme, rest, ok := strings.Cut(target, "://")
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+	}
+	scheme = strings.ToLower(scheme)
+	if scheme != "http" && scheme != "https" {
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrInvalidTarget, scheme)
+	}
+
+	end := strings.IndexAny(rest, "/?")
+	if end == -1 {
+		end = len(rest)
+	}
+	host := rest[:end]
+	if host == "" || strings.Contains(host, "@") {
+		// credentials in the target are deprecated and a common way to
+		// disguise the host
+		return nil, fmt.Errorf("%w: bad authority %q", ErrInvalidTarget, host)
+	}
+
+	u := &URL{Form: AbsoluteForm, Scheme: scheme, Host: host, slashes: slashes}
+	pathAndQuery := rest[end:]
+	if !strings.HasPrefix(pathAndQuery, "/") {
+		pathAndQuery = "/" + pathAndQuery
+	}
+	if err := u.parsePathAndQuery(pathAndQuery); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func parseAuthorityForm(target string) (*URL, error) {
+	idx := strings.LastIndexByte(target, ':')
+	if idx <= 0 || strings.ContainsAny(target, "/?@") {
+		return nil, fmt.Errorf("%w: CONNECT needs a host and a port: %q", ErrInvalidTarget, target)
+	}
+	port := target[idx+1:]
+	if port == "" {
+		return nil, fmt.Errorf("%w: empty port in %q", ErrInvalidTarget, target)
+	}
+	for i := 0; i < len(port); i++ {
+		if !isDigit(port[i]) {
+			return nil, fmt.Errorf("%w: bad port in %q", ErrInvalidTarget, target)
+		}
+	}
+	return &URL{Form: AuthorityForm, Host: target, Query: Values{}}, nil
+}
+
+// parsePathAndQuery fills the path and query of u from target, which starts
+// with "/"
+func (u *URL) parsePathAndQuery(target string) error {
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+	keepEncoded := ""
+	switch u.slashes {
+	case KeepSlashes:
+		keepEncoded = "/%"
+	case RejectSlashes:
+		if strings.Contains(strings.ToUpper(rawPath), "%2F") {
+			return fmt.Errorf("%w: encoded slash in %q", ErrInvalidTarget, rawPath)
+		}
+	}
+	path, err := unescape(rawPath, false, keepEncoded)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidTarget, err)
+	}
+	if strings.ContainsFunc(path, func(c rune) bool { return c < 0x20 || c == 0x7f }) {
+		// a NUL or a CRLF would reach file names and logs
+		return fmt.Errorf("%w: control character in %q", ErrInvalidTarget, rawPath)
+	}
+	query, err := ParseQuery(rawQuery)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidTarget, err)
+	}
+	u.Path, u.RawPath = path, rawPath
+	u.CleanPath = cleanPath(path)
+	u.RawQuery, u.Query = rawQuery, query
+	return nil
+}
+
+// ParseQuery decodes the parameters of a query or of a form body encoded as
+// application/x-www-form-urlencoded, where "+" stands for a space
+func ParseQuery(query string) (Values, error) {
+	values := Values{}
+	for _, param := range strings.Split(query, "&") {
+		if param == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(param, "=")
+		key, err := unescape(rawKey, true, "")
+		if err != nil {
+			return nil, err
+		}
+		value, err := unescape(rawValue, true, "")
+		if err != nil {
+			return nil, err
+		}
+		values.Add(key, value)
+	}
+	return values, nil
+}
+
+// unescape decodes the percent-encoded bytes of s, but the ones listed in
+// keepEncoded, and "+" as a space when plusAsSpace is set
+func unescape(s string, plusAsSpace bool, keepEncoded string) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '%':
+			if i+2 >= len(s) || !isHexDigit(s[i+1]) || !isHexDigit(s[i+2]) {
+				return "", fmt.Errorf("bad percent-encoding in %q", s)
+			}
+			decoded := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if strings.IndexByte(keepEncoded, decoded) != -1 {
+				fmt.Fprintf(&b, "%%%02X", decoded)
+			} else {
+				b.WriteByte(decoded)
+			}
+			i += 2
+		case c == '+' && plusAsSpace:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// cleanPath returns the canonical form of path, which starts with "/": the
+// "." and ".." segments are resolved as in RFC 3986 section 5.2.4, without
+// going above the root, and empty segments are dropped. A trailing slash is
+// kept.
+func cleanPath(path string) string {
+	segments := strings.Split(path[1:], "/")
+	cleaned := make([]string, 0, len(segments))
+	trailingSlash := false
+	for _, segment := range segments {
+		switch segment {
+		case "", ".":
+			trailingSlash = true
+		case "..":
+			if len(cleaned) > 0 {
+				cleaned = cleaned[:len(cleaned)-1]
+			}
+			trailingSlash = true
+		default:
+			cleaned = append(cleaned, segment)
+			trailingSlash = false
+		}
+	}
+	if len(cleaned) == 0 {
+		return "/"
+	}
+	if trailingSlash {
+		return "/" + strings.Join(cleaned, "/") + "/"
+	}
+	return "/" + strings.Join(cleaned, "/")
+}
+
+// CanonicalTarget returns the target to redirect a request to so its path
+// is canonical: CleanPath, percent-encoded, followed by the query
+func (u *URL) CanonicalTarget() string {
+	var b strings.Builder
+	for i := 0; i < len(u.CleanPath); i++ {
+		c := u.CleanPath[i]
+		if shouldEscape(c) && (c != '%' || u.slashes != KeepSlashes) {
+			// with KeepSlashes, "%" only starts the encodings left as is
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	if u.RawQuery != "" {
+		b.WriteString("?" + u.RawQuery)
+	}
+	return b.String()
+}
+
+// shouldEscape reports whether c has to be percent-encoded in a path, where
+// only unreserved characters, sub-delims, ":", "@" and "/" are allowed
+func shouldEscape(c byte) bool {
+	if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || isDigit(c) {
+		return false
+	}
+	return !strings.ContainsRune("-._~!$&'()*+,;=:@/", rune(c))
+}
@@ -0,0 +1,48 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteStatusLine(t *testing.T) {
+	tests := []struct {
+		code StatusCode
+		line string
+	}{
+		{Ok, "HTTP/1.1 200 OK\r\n"},
+		{Continue, "HTTP/1.1 100 Continue\r\n"},
+		{PermanentRedirect, "HTTP/1.1 308 Permanent Redirect\r\n"},
+		{UnprocessableContent, "HTTP/1.1 422 Unprocessable Content\r\n"},
+		{GatewayTimeout, "HTTP/1.1 504 Gateway Timeout\r\n"},
+		// unregistered codes keep an empty reason phrase
+		{599, "HTTP/1.1 599 \r\n"},
+		{999, "HTTP/1.1 999 \r\n"},
+	}
+	for _, tt := range tests {
+		buf := &bytes.Buffer{}
+		require.NoError(t, WriteStatusLine(buf, tt.code))
+		assert.Equal(t, tt.line, buf.String())
+	}
+
+	for _, code := range []StatusCode{0, 99, 1000, -200} {
+		buf := &bytes.Buffer{}
+		err := WriteStatusLine(buf, code)
+		assert.ErrorIs(t, err, ErrInvalidStatusCode)
+		assert.Empty(t, buf.String())
+	}
+
+	// Test: Writer rejects the code before anything is sent
+	w := NewWriter(&bytes.Buffer{})
+	assert.ErrorIs(t, w.WriteStatusLine(42), ErrInvalidStatusCode)
+}
+
+func TestStatusText(t *testing.T) {
+	assert.Equal(t, "Not Found", StatusText(NotFound))
+	assert.Equal(t, "Non-Authoritative Information", StatusText(NonAuthoritativeInformation))
+	assert.Equal(t, "Request Header Fields Too Large", StatusText(RequestHeaderFieldsTooLarge))
+	assert.Equal(t, "", StatusText(299))
+}
@@ -0,0 +1,146 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+type StatusCode int
+
+// the status codes registered by RFC 9110, along with the ones from RFC 6585
+// that the server itself may send
+const (
+	Continue           StatusCode = 100
+	SwitchingProtocols StatusCode = 101
+
+	Ok                          StatusCode = 200
+	Created                     StatusCode = 201
+	Accepted                    StatusCode = 202
+	NonAuthoritativeInformation StatusCode = 203
+	NoContent                   StatusCode = 204
+	ResetContent                StatusCode = 205
+	PartialContent              StatusCode = 206
+
+	MultipleChoices   StatusCode = 300
+	MovedPermanently  StatusCode = 301
+	Found             StatusCode = 302
+	SeeOther          StatusCode = 303
+	NotModified       StatusCode = 304
+	UseProxy          StatusCode = 305
+	TemporaryRedirect StatusCode = 307
+	PermanentRedirect StatusCode = 308
+
+	BadRequest                  StatusCode = 400
+	Unauthorized                StatusCode = 401
+	PaymentRequired             StatusCode = 402
+	Forbidden                   StatusCode = 403
+	NotFound                    StatusCode = 404
+	MethodNotAllowed            StatusCode = 405
+	NotAcceptable               StatusCode = 406
+	ProxyAuthenticationRequired StatusCode = 407
+	RequestTimeout              StatusCode = 408
+	Conflict                    StatusCode = 409
+	Gone                        StatusCode = 410
+	LengthRequired              StatusCode = 411
+	PreconditionFailed          StatusCode = 412
+	ContentTooLarge             StatusCode = 413
+	URITooLong                  StatusCode = 414
+	UnsupportedMediaType        StatusCode = 415
+	RangeNotSatisfiable         StatusCode = 416
+	ExpectationFailed           StatusCode = 417
+	MisdirectedRequest          StatusCode = 421
+	UnprocessableContent        StatusCode = 422
+	UpgradeRequired             StatusCode = 426
+	PreconditionRequired        StatusCode = 428
+	TooManyRequests             StatusCode = 429
+	RequestHeaderFieldsTooLarge StatusCode = 431
+
+	InternalServerError           StatusCode = 500
+	NotImplemented                StatusCode = 501
+	BadGateway                    StatusCode = 502
+	ServiceUnavailable            StatusCode = 503
+	GatewayTimeout                StatusCode = 504
+	HTTPVersionNotSupported       StatusCode = 505
+	NetworkAuthenticationRequired StatusCode = 511
+)
+
+var ErrInvalidStatusCode = errors.New("invalid status code")
+
+var reasonPhrases = map[StatusCode]string{
+	Continue:           "Continue",
+	SwitchingProtocols: "Switching Protocols",
+
+	Ok:                          "OK",
+	Created:                     "Created",
+	Accepted:                    "Accepted",
+	NonAuthoritativeInformation: "Non-Authoritative Information",
+	NoContent:                   "No Content",
+	ResetContent:                "Reset Content",
+	PartialContent:              "Partial Content",
+
+	MultipleChoices:   "Multiple Choices",
+	MovedPermanently:  "Moved Permanently",
+	Found:             "Found",
+	SeeOther:          "See Other",
+	NotModified:       "Not Modified",
+	UseProxy:          "Use Proxy",
+	TemporaryRedirect: "Temporary Redirect",
+	PermanentRedirect: "Permanent Redirect",
+
+	BadRequest:                  "Bad Request",
+	Unauthorized:                "Unauthorized",
+	PaymentRequired:             "Payment Required",
+	Forbidden:                   "Forbidden",
+	NotFound:                    "Not Found",
+	MethodNotAllowed:            "Method Not Allowed",
+	NotAcceptable:               "Not Acceptable",
+	ProxyAuthenticationRequired: "Proxy Authentication Required",
+	RequestTimeout:              "Request Timeout",
+	Conflict:                    "Conflict",
+	Gone:                        "Gone",
+	LengthRequired:              "Length Required",
+	PreconditionFailed:          "Precondition Failed",
+	ContentTooLarge:             "Content Too Large",
+	URITooLong:                  "URI Too Long",
+	UnsupportedMediaType:        "Unsupported Media Type",
+	RangeNotSatisfiable:         "Range Not Satisfiable",
+	ExpectationFailed:           "Expectation Failed",
+	MisdirectedRequest:          "Misdirected Request",
+	UnprocessableContent:        "Unprocessable Content",
+	UpgradeRequired:             "Upgrade Required",
+	PreconditionRequired:        "Precondition Required",
+	TooManyRequests:             "Too Many Requests",
+	RequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+
+	InternalServerError:           "Internal Server Error",
+	NotImplemented:                "Not Implemented",
+	BadGateway:                    "Bad Gateway",
+	ServiceUnavailable:            "Service Unavailable",
+	GatewayTimeout:                "Gateway Timeout",
+	HTTPVersionNotSupported:       "HTTP Version Not Supported",
+	NetworkAuthenticationRequired: "Network Authentication Required",
+}
+
+// StatusText returns the reason phrase of statusCode, or "" if the code
+// isn't registered
+func StatusText(statusCode StatusCode) string {
+	return reasonPhrases[statusCode]
+}
+
+// validStatusCode reports whether statusCode can be sent in a status line,
+// which allows any three digit code
+func validStatusCode(statusCode StatusCode) bool {
+	return statusCode >= 100 && statusCode <= 999
+}
+
+// WriteStatusLine writes the HTTP/1.1 status line of statusCode. Codes that
+// aren't registered are sent with an empty reason phrase, which is still a
+// valid status line.
+func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
+	if !validStatusCode(statusCode) {
+		return fmt.Errorf("%w: %d", ErrInvalidStatusCode, statusCode)
+	}
+	_, err := fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n", statusCode, StatusText(statusCode))
+	return err
+}
@@ -1,8 +1,13 @@
 package response
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/lealre/httpfromtcp/internal/headers"
 )
@@ -13,100 +18,709 @@ const (
 	writerStarted responseWriterStatus = iota
 	statusLineDone
 	headersDone
+	writingBody
+	chunkedBody
 	bodyDone
+	// writerFailed is entered after an error writing to the connection, after
+	// which every method returns that error
+	writerFailed
 )
 
+var (
+	ErrWrongOrder        = errors.New("trying to write the response in the wrong order")
+	ErrNotChunked        = errors.New("response body isn't chunked")
+	ErrUndeclaredTrailer = errors.New("trailer field not declared in the Trailer header")
+	ErrForbiddenTrailer  = errors.New("field not allowed in trailers")
+	// ErrBodyTooLong and ErrBodyTooShort report a body that doesn't match
+	// the Content-Length of the response
+	ErrBodyTooLong  = errors.New("body longer than the declared Content-Length")
+	ErrBodyTooShort = errors.New("body shorter than the declared Content-Length")
+)
+
+// forbiddenTrailers are the fields needed before the body to frame, route,
+// authenticate or process the message, which can't be sent as trailers
+// (RFC 9110 section 6.5.1)
+var forbiddenTrailers = []string{
+	"Authorization", "Cache-Control", "Connection", "Content-Encoding",
+	"Content-Length", "Content-Range", "Content-Type", "Expect", "Host",
+	"Keep-Alive", "Max-Forwards", "Pragma", "Proxy-Authenticate",
+	"Proxy-Authorization", "Proxy-Connection", "Range", "Set-Cookie", "Te",
+	"Trailer", "Transfer-Encoding", "Www-Authenticate",
+}
+
+// TimeFormat is the IMF-fixdate format of the Date header
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// DefaultBufferSize is how much of the body sent with Write is held back,
+// unless changed with SetBufferSize
+const DefaultBufferSize = 4096
+
 type Writer struct {
 	Writer       io.Writer
 	writerStatus responseWriterStatus
+	statusCode   StatusCode
+	header       *headers.Headers
+	buf          []byte
+	bufferSize   int
+	chunked      bool
+	// contentLength is the declared length of the body being sent, or -1
+	// when it isn't enforced, and contentWritten how much of it was sent
+	contentLength   int
+	contentWritten  int
+	closeConnection bool
+	discardBody     bool
+	bytesWritten    int
+	headerHooks     []HeaderHook
+	// filters transform the body, the last one receiving what the handler
+	// writes
+	filters []io.WriteCloser
+	err     error
 }
 
+// HeaderHook is called right before the headers are written, with the status
+// of the response. It can modify the headers about to be sent.
+type HeaderHook func(statusCode StatusCode, h *headers.Headers)
+
+// BodyFilter wraps dst, where the body goes, to transform the body before it
+// is framed and sent, e.g. to compress it. Close is called at the end of the
+// body, to write what the filter still holds, and Flush, if the filter has
+// one, when the body is flushed.
+type BodyFilter func(dst io.Writer) io.WriteCloser
+
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{
-		Writer:       w,
-		writerStatus: writerStarted,
+		Writer:        w,
+		writerStatus:  writerStarted,
+		header:        headers.NewHeaders(),
+		bufferSize:    DefaultBufferSize,
+		contentLength: -1,
 	}
 }
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	if w.writerStatus != writerStarted {
-		return fmt.Errorf("trying to write the reponse in the wrong order")
+	if err := w.expect(writerStarted); err != nil {
+		return err
+	}
+	if !validStatusCode(statusCode) {
+		return fmt.Errorf("%w: %d", ErrInvalidStatusCode, statusCode)
 	}
 
-	defer func() { w.writerStatus = statusLineDone }()
-
-	WriteStatusLine(w.Writer, statusCode)
+	// the status line is sent along with the headers, once they are known
+	// to be valid
+	w.statusCode = statusCode
+	w.writerStatus = statusLineDone
 	return nil
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
-	if w.writerStatus != statusLineDone {
-		return fmt.Errorf("trying to write the reponse in the wrong order")
+// Header returns the headers of the response, which can be changed until
+// they are written
+func (w *Writer) Header() *headers.Headers {
+	return w.header
+}
+
+// OnWriteHeaders registers hook to run before the headers are written. Hooks
+// run in the order they were registered.
+func (w *Writer) OnWriteHeaders(hook HeaderHook) {
+	w.headerHooks = append(w.headerHooks, hook)
+}
+
+// FilterBody registers filter to transform the body. The body goes through
+// the filters from the last registered one to the first, so the first one
+// sees what is sent, and BytesWritten counts the filtered body. Filters
+// changing the length of the body must drop any Content-Length set by the
+// handler, e.g. from a HeaderHook. FilterBody must be called before the body
+// is written.
+func (w *Writer) FilterBody(filter BodyFilter) error {
+	if err := w.expect(writerStarted, statusLineDone, headersDone); err != nil {
+		return err
+	}
+	if len(w.buf) > 0 {
+		return ErrWrongOrder
+	}
+	var dst io.Writer = filteredBody{w}
+	if len(w.filters) > 0 {
+		dst = w.filters[len(w.filters)-1]
 	}
+	w.filters = append(w.filters, filter(dst))
+	return nil
+}
+
+// filteredBody receives the body from the filters
+type filteredBody struct {
+	w *Writer
+}
+
+func (f filteredBody) Write(p []byte) (int, error) {
+	return f.w.writeBody(p)
+}
 
-	defer func() { w.writerStatus = headersDone }()
+// bodyWriter returns where the body written by the handler goes: the last
+// filter, or the writer itself
+func (w *Writer) bodyWriter() io.Writer {
+	if len(w.filters) > 0 {
+		return w.filters[len(w.filters)-1]
+	}
+	return filteredBody{w}
+}
 
-	for key, value := range headers {
-		keyPairHeaderValue := fmt.Sprintf("%s: %s\r\n", key, value)
-		_, err := w.Writer.Write([]byte(keyPairHeaderValue))
-		if err != nil {
+// closeFilters ends the filtered body, letting each filter write what it
+// still holds
+func (w *Writer) closeFilters() error {
+	filters := w.filters
+	w.filters = nil
+	for i := len(filters) - 1; i >= 0; i-- {
+		if err := filters[i].Close(); err != nil {
 			return err
 		}
 	}
-	w.Writer.Write([]byte("\r\n"))
 	return nil
 }
 
-func (w *Writer) WriteBody(p []byte) (int, error) {
-	if w.writerStatus != headersDone {
-		return 0, fmt.Errorf("trying to write the reponse in the wrong order")
+// Status returns the status code of the response, or 0 if the status line
+// wasn't written yet
+func (w *Writer) Status() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten returns how many bytes of body were written, buffered ones
+// included, not counting the chunked encoding framing
+func (w *Writer) BytesWritten() int {
+	return w.bytesWritten
+}
+
+// Committed reports whether the status line and the headers were sent, or
+// failed to be, after which the response can't be replaced by another one
+func (w *Writer) Committed() bool {
+	return w.writerStatus >= headersDone
+}
+
+// DiscardBody makes the writer drop the body while still writing the status
+// line and the headers, as required for the response to a HEAD request
+func (w *Writer) DiscardBody() {
+	w.discardBody = true
+}
+
+// SetBufferSize changes how much of the body sent with Write is held back.
+// While the whole body fits in the buffer, it's sent with a Content-Length;
+// past it, the headers are written and the body is sent in chunks of about
+// that size. It must be called before anything is written.
+func (w *Writer) SetBufferSize(size int) {
+	w.bufferSize = size
+}
+
+// CloseAfterResponse makes the response carry a "connection: close" header,
+// telling the client the connection is closed once the response is sent.
+// It must be called before the headers are written.
+func (w *Writer) CloseAfterResponse() {
+	w.closeConnection = true
+}
+
+// ClosesConnection reports whether the connection has to be closed after
+// the response: either it was asked for, the response has no framing so its
+// end is signalled by closing the connection, or no response was written in
+// full.
+func (w *Writer) ClosesConnection() bool {
+	return w.closeConnection || w.writerStatus < headersDone || w.writerStatus == writerFailed
+}
+
+// Err returns the error that made the writer fail, if any
+func (w *Writer) Err() error {
+	return w.err
+}
+
+// expect returns an error unless the writer is in one of states
+func (w *Writer) expect(states ...responseWriterStatus) error {
+	if w.writerStatus == writerFailed {
+		return w.err
 	}
+	for _, state := range states {
+		if w.writerStatus == state {
+			return nil
+		}
+	}
+	return ErrWrongOrder
+}
 
-	defer func() { w.writerStatus = bodyDone }()
+// write sends p to the underlying writer. Any error is final: the writer
+// can't tell how much of the response the client got.
+func (w *Writer) write(p []byte) (int, error) {
 	n, err := w.Writer.Write(p)
 	if err != nil {
-		return 0, err
+		w.writerStatus = writerFailed
+		w.err = err
+	}
+	return n, err
+}
+
+// WriteHeaders sends the status line and the headers of the response: the
+// ones set through Header, replaced by the fields of h with the same name.
+// A Date header is added if there is none.
+func (w *Writer) WriteHeaders(h *headers.Headers) error {
+	if err := w.expect(statusLineDone); err != nil {
+		return err
+	}
+
+	headers := w.header
+	for key := range h.All() {
+		headers.Remove(key)
+	}
+	for key, value := range h.All() {
+		headers.Add(key, value)
+	}
+	if headers.Get("Date") == "" {
+		headers.Add("Date", time.Now().UTC().Format(TimeFormat))
+	}
+	for _, hook := range w.headerHooks {
+		hook(w.statusCode, headers)
+	}
+	if err := headers.Validate(); err != nil {
+		return err
+	}
+	contentLength, err := declaredLength(headers)
+	if err != nil {
+		return err
+	}
+
+	if headers.HasToken("connection", "close") || !w.hasFraming(headers) {
+		w.closeConnection = true
+	}
+	w.chunked = headers.HasToken("transfer-encoding", "chunked")
+	if !w.chunked && !w.discardBody && bodyAllowed(w.statusCode) {
+		w.contentLength = contentLength
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteStatusLine(buf, w.statusCode); err != nil {
+		return err
+	}
+	for key, value := range headers.All() {
+		if key == "Connection" && w.closeConnection {
+			continue
+		}
+		fmt.Fprintf(buf, "%s: %s\r\n", key, value)
+	}
+	if w.closeConnection {
+		buf.WriteString("Connection: close\r\n")
+	}
+	buf.WriteString("\r\n")
+
+	if _, err := w.write(buf.Bytes()); err != nil {
+		return err
+	}
+	w.writerStatus = headersDone
+	return nil
+}
+
+// declaredLength returns the Content-Length in h, or -1 if there is none
+func declaredLength(h *headers.Headers) (int, error) {
+	value := h.Get("Content-Length")
+	if value == "" {
+		return -1, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w for Content-Length: %q", headers.ErrInvalidFieldValue, value)
 	}
 	return n, nil
 }
 
+// writeContent writes p as part of a body that isn't chunked, refusing to
+// go past its declared Content-Length
+func (w *Writer) writeContent(p []byte) (int, error) {
+	if w.contentLength >= 0 && w.contentWritten+len(p) > w.contentLength {
+		return 0, fmt.Errorf("%w: %d bytes declared, %d written",
+			ErrBodyTooLong, w.contentLength, w.contentWritten+len(p))
+	}
+	n, err := w.write(p)
+	w.contentWritten += n
+	return n, err
+}
+
+// hasFraming reports whether the client can find the end of the response
+// body without the connection being closed
+func (w *Writer) hasFraming(headers *headers.Headers) bool {
+	if !bodyAllowed(w.statusCode) {
+		return true
+	}
+	return headers.Get("content-length") != "" || headers.Get("transfer-encoding") != ""
+}
+
+// bodyAllowed reports whether a response with statusCode can have a body
+func bodyAllowed(statusCode StatusCode) bool {
+	return statusCode >= 200 && statusCode != NoContent && statusCode != NotModified
+}
+
+// WriteBody writes p as is, after the body buffered by Write if any. A
+// chunked body has to be written with WriteChunkedBody or Write instead.
+func (w *Writer) WriteBody(p []byte) (int, error) {
+	if err := w.expect(headersDone, writingBody); err != nil {
+		return 0, err
+	}
+	if w.chunked {
+		return 0, fmt.Errorf("%w: the body is chunked", ErrWrongOrder)
+	}
+	if len(w.filters) > 0 {
+		return w.bodyWriter().Write(p)
+	}
+	if err := w.flushBuffer(); err != nil {
+		return 0, err
+	}
+	w.writerStatus = writingBody
+
+	if w.discardBody {
+		return len(p), nil
+	}
+	n, err := w.writeContent(p)
+	w.bytesWritten += n
+	return n, err
+}
+
+// Write writes p as part of the body, making Writer an io.Writer. Writing
+// before the status line implies a 200.
+//
+// Writes are buffered. If the handler is done before the body outgrows the
+// buffer, the body is sent with its Content-Length. Otherwise the headers
+// are written at that point and, unless the handler set the framing, the
+// body is sent with the chunked encoding, small writes being coalesced
+// into chunks about the size of the buffer.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.writerStatus == writerStarted {
+		if err := w.WriteStatusLine(Ok); err != nil {
+			return 0, err
+		}
+	}
+	return w.bodyWriter().Write(p)
+}
+
+// writeBody buffers p, or sends it once the headers are written, as
+// described by Write
+func (w *Writer) writeBody(p []byte) (int, error) {
+	switch w.writerStatus {
+	case writerStarted:
+		if err := w.WriteStatusLine(Ok); err != nil {
+			return 0, err
+		}
+		fallthrough
+	case statusLineDone:
+		// an invalid Content-Length is reported once the headers are sent
+		if declared, err := declaredLength(w.header); err == nil && declared >= 0 && len(w.buf)+len(p) > declared {
+			return 0, fmt.Errorf("%w: %d bytes declared, %d written", ErrBodyTooLong, declared, len(w.buf)+len(p))
+		}
+		if len(w.buf)+len(p) <= w.bufferSize {
+			w.buffer(p)
+			return len(p), nil
+		}
+		if err := w.commit(); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := w.expect(headersDone, w.bodyState()); err != nil {
+		return 0, err
+	}
+	w.writerStatus = w.bodyState()
+	if w.contentLength >= 0 && w.contentWritten+len(w.buf)+len(p) > w.contentLength {
+		return 0, fmt.Errorf("%w: %d bytes declared, %d written",
+			ErrBodyTooLong, w.contentLength, w.contentWritten+len(w.buf)+len(p))
+	}
+	if len(w.buf)+len(p) <= w.bufferSize {
+		w.buffer(p)
+		return len(p), nil
+	}
+	if err := w.flushBuffer(); err != nil {
+		return 0, err
+	}
+	if len(p) < w.bufferSize {
+		w.buffer(p)
+		return len(p), nil
+	}
+	if w.discardBody {
+		return len(p), nil
+	}
+	if w.chunked {
+		if _, err := w.writeChunk(p); err != nil {
+			return 0, err
+		}
+		w.bytesWritten += len(p)
+		return len(p), nil
+	}
+	n, err := w.writeContent(p)
+	w.bytesWritten += n
+	return n, err
+}
+
+// Flush sends the buffered body right away, after flushing the filters that
+// can be. If the headers weren't written yet, they are, and the body is
+// chunked unless the handler set its framing.
+func (w *Writer) Flush() error {
+	for i := len(w.filters) - 1; i >= 0; i-- {
+		if flusher, ok := w.filters[i].(interface{ Flush() error }); ok {
+			if err := flusher.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	switch w.writerStatus {
+	case writerStarted:
+		if err := w.WriteStatusLine(Ok); err != nil {
+			return err
+		}
+		fallthrough
+	case statusLineDone:
+		if err := w.commit(); err != nil {
+			return err
+		}
+	}
+	return w.flushBuffer()
+}
+
+// buffer holds p back until the buffer is flushed
+func (w *Writer) buffer(p []byte) {
+	if w.discardBody {
+		// only the length matters, for the Content-Length
+		w.buf = append(w.buf, make([]byte, len(p))...)
+		return
+	}
+	w.buf = append(w.buf, p...)
+	w.bytesWritten += len(p)
+}
+
+// bodyState is the state of the writer while the body is sent by Write
+func (w *Writer) bodyState() responseWriterStatus {
+	if w.chunked {
+		return chunkedBody
+	}
+	return writingBody
+}
+
+// commit writes the headers before the end of the body is known, choosing
+// the chunked encoding unless the handler set the framing
+func (w *Writer) commit() error {
+	if bodyAllowed(w.statusCode) &&
+		w.header.Get("Content-Length") == "" && w.header.Get("Transfer-Encoding") == "" {
+		w.header.Add("Transfer-Encoding", "chunked")
+	}
+	return w.WriteHeaders(headers.NewHeaders())
+}
+
+// flushBuffer writes the body buffered by Write, once the headers are
+func (w *Writer) flushBuffer() error {
+	if w.writerStatus == writerFailed {
+		return w.err
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = w.buf[:0]
+	if w.writerStatus == headersDone {
+		w.writerStatus = w.bodyState()
+	}
+	if w.discardBody {
+		return nil
+	}
+	if w.chunked {
+		_, err := w.writeChunk(buf)
+		return err
+	}
+	_, err := w.writeContent(buf)
+	return err
+}
+
+// Finish completes the response once the handler is done. It writes what
+// wasn't yet: a 200 status line, the headers, with the Content-Length of
+// the buffered body unless the framing was set, and the buffered body. It
+// also ends a chunked body left open.
+//
+// It returns an error wrapping ErrBodyTooShort if less body than declared
+// by the Content-Length was written, in which case the connection can't be
+// reused.
+func (w *Writer) Finish() error {
+	if w.writerStatus < bodyDone {
+		if err := w.closeFilters(); err != nil {
+			return err
+		}
+	}
+	switch w.writerStatus {
+	case writerStarted:
+		if err := w.WriteStatusLine(Ok); err != nil {
+			return err
+		}
+		fallthrough
+	case statusLineDone:
+		if !bodyAllowed(w.statusCode) {
+			w.buf = nil
+		} else if err := w.checkBufferedLength(); err != nil {
+			return err
+		} else if w.header.Get("Content-Length") == "" && w.header.Get("Transfer-Encoding") == "" {
+			w.header.Add("Content-Length", strconv.Itoa(len(w.buf)))
+		}
+		if err := w.WriteHeaders(headers.NewHeaders()); err != nil {
+			return err
+		}
+	case bodyDone:
+		return nil
+	case writerFailed:
+		return w.err
+	}
+
+	if w.chunked {
+		_, err := w.WriteChunkedBodyDone()
+		return err
+	}
+	if err := w.flushBuffer(); err != nil {
+		return err
+	}
+	if w.contentLength >= 0 && w.contentWritten < w.contentLength {
+		return fmt.Errorf("%w: %d bytes declared, %d written",
+			ErrBodyTooShort, w.contentLength, w.contentWritten)
+	}
+	w.writerStatus = bodyDone
+	return nil
+}
+
+// checkBufferedLength returns an error if the whole body, buffered, doesn't
+// match the Content-Length set by the handler, before anything is sent. A
+// response to HEAD can declare a length without writing the body.
+func (w *Writer) checkBufferedLength() error {
+	declared, err := declaredLength(w.header)
+	if err != nil || declared < 0 || (w.discardBody && len(w.buf) == 0) {
+		return err
+	}
+	if len(w.buf) > declared {
+		return fmt.Errorf("%w: %d bytes declared, %d written", ErrBodyTooLong, declared, len(w.buf))
+	}
+	if len(w.buf) < declared {
+		return fmt.Errorf("%w: %d bytes declared, %d written", ErrBodyTooShort, declared, len(w.buf))
+	}
+	return nil
+}
+
+// WriteChunkedBody sends p as a single chunk, after the body buffered by
+// Write if any. Empty writes send nothing, as an empty chunk would end the
+// body.
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-	bytesSize := fmt.Sprintf("%x\r\n", len(p))
-	representationSize, err := w.Writer.Write([]byte(bytesSize))
-	if err != nil {
+	if err := w.expect(headersDone, writingBody, chunkedBody); err != nil {
 		return 0, err
 	}
-	chunkSize, err := w.Writer.Write(p)
-	if err != nil {
+	if !w.chunked {
+		return 0, ErrNotChunked
+	}
+	if len(w.filters) > 0 {
+		// the filtered body is chunked again as it comes out of the filters
+		return w.bodyWriter().Write(p)
+	}
+	if err := w.flushBuffer(); err != nil {
 		return 0, err
 	}
-	w.Writer.Write([]byte("\r\n"))
+	w.writerStatus = chunkedBody
+
+	if w.discardBody || len(p) == 0 {
+		return len(p), nil
+	}
+	n, err := w.writeChunk(p)
+	if err != nil {
+		return n, err
+	}
+	w.bytesWritten += len(p)
+	return n, nil
+}
 
-	return representationSize + chunkSize, nil
+// writeChunk sends p framed as a chunk
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	buf := make([]byte, 0, len(p)+20)
+	buf = fmt.Appendf(buf, "%x\r\n", len(p))
+	buf = append(buf, p...)
+	buf = append(buf, "\r\n"...)
+	return w.write(buf)
 }
 
+// WriteChunkedBodyDone ends a chunked body without trailers
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
-	totalBytes, err := w.Writer.Write([]byte("0\r\n\r\n"))
-	if err != nil {
+	if err := w.expect(headersDone, writingBody, chunkedBody); err != nil {
+		return 0, err
+	}
+	if !w.chunked {
+		return 0, ErrNotChunked
+	}
+	if err := w.closeFilters(); err != nil {
 		return 0, err
 	}
-	return totalBytes, nil
+	return w.endChunkedBody(nil)
 }
 
-func (w *Writer) WriteTrailers(h headers.Headers) error {
-	// trailersValues := h.Get("Trailer")
-	// if trailersValues == "" {
-	// 	return fmt.Errorf("no trailer key found in headers")
-	// }
-
-	w.Writer.Write([]byte("0\r\n"))
-	for key, value := range h {
-		keyPairHeaderValue := fmt.Sprintf("%s: %s\r\n", key, value)
-		_, err := w.Writer.Write([]byte(keyPairHeaderValue))
-		if err != nil {
+// WriteTrailers ends a chunked body: it writes the last chunk, the fields
+// of h as trailers and the final CRLF. Each field must be announced in the
+// Trailer header of the response. If the headers weren't written yet, they
+// are, with the chunked encoding.
+func (w *Writer) WriteTrailers(h *headers.Headers) error {
+	if err := w.expect(statusLineDone, headersDone, chunkedBody); err != nil {
+		return err
+	}
+	if err := w.closeFilters(); err != nil {
+		return err
+	}
+	if w.writerStatus == statusLineDone {
+		if w.header.Get("Content-Length") != "" {
+			return ErrNotChunked
+		}
+		if err := w.checkTrailers(h); err != nil {
 			return err
 		}
+		if err := w.commit(); err != nil {
+			return err
+		}
+	}
+	if !w.chunked {
+		return ErrNotChunked
+	}
+	if err := w.checkTrailers(h); err != nil {
+		return err
+	}
+	_, err := w.endChunkedBody(h)
+	return err
+}
+
+// checkTrailers returns an error if a field of h can't be sent as a
+// trailer of the response
+func (w *Writer) checkTrailers(h *headers.Headers) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	for name := range h.All() {
+		if slices.Contains(forbiddenTrailers, name) {
+			return fmt.Errorf("%w: %s", ErrForbiddenTrailer, name)
+		}
+		if !w.header.HasToken("Trailer", name) {
+			return fmt.Errorf("%w: %s", ErrUndeclaredTrailer, name)
+		}
 	}
-	w.Writer.Write([]byte("\r\n"))
 	return nil
 }
+
+// endChunkedBody writes the last chunk, the trailers if any and the final
+// CRLF, after the body buffered by Write
+func (w *Writer) endChunkedBody(trailers *headers.Headers) (int, error) {
+	if err := w.flushBuffer(); err != nil {
+		return 0, err
+	}
+
+	if w.discardBody {
+		w.writerStatus = bodyDone
+		return 0, nil
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("0\r\n")
+	if trailers != nil {
+		for key, value := range trailers.All() {
+			fmt.Fprintf(buf, "%s: %s\r\n", key, value)
+		}
+	}
+	buf.WriteString("\r\n")
+	n, err := w.write(buf.Bytes())
+	if err != nil {
+		return n, err
+	}
+	w.writerStatus = bodyDone
+	return n, nil
+}
@@ -0,0 +1,515 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lealre/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingWriter accepts limit bytes, then fails every write
+type failingWriter struct {
+	limit int
+	buf   bytes.Buffer
+}
+
+var errBrokenPipe = errors.New("broken pipe")
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.buf.Len()+len(p) > f.limit {
+		n := f.limit - f.buf.Len()
+		f.buf.Write(p[:n])
+		return n, errBrokenPipe
+	}
+	return f.buf.Write(p)
+}
+
+func TestWriterOrder(t *testing.T) {
+	// Test: Body before headers
+	w := NewWriter(&bytes.Buffer{})
+	_, err := w.WriteBody([]byte("hi"))
+	assert.ErrorIs(t, err, ErrWrongOrder)
+	_, err = w.WriteChunkedBody([]byte("hi"))
+	assert.ErrorIs(t, err, ErrWrongOrder)
+	assert.ErrorIs(t, w.WriteTrailers(headers.NewHeaders()), ErrWrongOrder)
+	assert.ErrorIs(t, w.WriteHeaders(headers.NewHeaders()), ErrWrongOrder)
+	assert.False(t, w.Committed())
+
+	// Test: Chunked body, then nothing after its end
+	buf := &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(Ok))
+	assert.ErrorIs(t, w.WriteStatusLine(Ok), ErrWrongOrder)
+	h := headers.NewHeaders()
+	h.Add("Transfer-Encoding", "chunked")
+	h.Add("Date", "Sun, 06 Nov 1994 08:49:37 GMT")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.True(t, w.Committed())
+	assert.Equal(t, Ok, w.Status())
+	_, err = w.WriteBody([]byte("plain"))
+	assert.ErrorIs(t, err, ErrWrongOrder)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("late"))
+	assert.ErrorIs(t, err, ErrWrongOrder)
+
+	w = NewWriter(buf)
+	buf.Reset()
+	require.NoError(t, w.WriteStatusLine(Ok))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody(nil)
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte(" world"))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("plain"))
+	assert.ErrorIs(t, err, ErrWrongOrder)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	assert.ErrorIs(t, err, ErrWrongOrder)
+	assert.ErrorIs(t, w.WriteTrailers(headers.NewHeaders()), ErrWrongOrder)
+	assert.Equal(t, 11, w.BytesWritten())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nDate: Sun, 06 Nov 1994 08:49:37 GMT\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n", buf.String())
+}
+
+func TestWriterFailure(t *testing.T) {
+	// Test: Failing while writing the headers
+	fw := &failingWriter{limit: 10}
+	w := NewWriter(fw)
+	require.NoError(t, w.WriteStatusLine(Ok))
+	err := w.WriteHeaders(GetDefaultHeaders(2))
+	assert.ErrorIs(t, err, errBrokenPipe)
+	assert.True(t, w.Committed())
+	assert.True(t, w.ClosesConnection())
+	_, err = w.WriteBody([]byte("hi"))
+	assert.ErrorIs(t, err, errBrokenPipe)
+	assert.ErrorIs(t, w.Err(), errBrokenPipe)
+
+	// Test: Failing in the middle of the body
+	fw = &failingWriter{limit: 130}
+	w = NewWriter(fw)
+	require.NoError(t, w.WriteStatusLine(Ok))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(100)))
+	n, err := w.WriteBody(bytes.Repeat([]byte("a"), 100))
+	assert.ErrorIs(t, err, errBrokenPipe)
+	assert.Less(t, n, 100)
+	assert.Equal(t, n, w.BytesWritten())
+	_, err = w.WriteChunkedBodyDone()
+	assert.ErrorIs(t, err, errBrokenPipe)
+	assert.True(t, w.ClosesConnection())
+}
+
+func TestWriterImplicitResponse(t *testing.T) {
+	// Test: Body buffered, Content-Length computed on Finish
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.Header().Set("Content-Type", "text/plain")
+	n, err := w.Write([]byte("hello "))
+	require.NoError(t, err)
+	assert.Equal(t, 6, n)
+	w.Write([]byte("world"))
+	assert.False(t, w.Committed())
+	assert.Equal(t, Ok, w.Status())
+	assert.Equal(t, 11, w.BytesWritten())
+	assert.ErrorIs(t, w.WriteStatusLine(NotFound), ErrWrongOrder)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.Committed())
+	assert.False(t, w.ClosesConnection())
+
+	res := buf.String()
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 11\r\nDate: "))
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\nhello world"))
+	date := strings.SplitN(strings.SplitN(res, "Date: ", 2)[1], "\r\n", 2)[0]
+	_, err = time.Parse(TimeFormat, date)
+	assert.NoError(t, err)
+
+	// Test: Nothing written at all
+	buf.Reset()
+	w = NewWriter(buf)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, buf.String(), "Content-Length: 0\r\n")
+
+	// Test: Explicit status, no Content-Length on 204
+	buf.Reset()
+	w = NewWriter(buf)
+	w.WriteStatusLine(NoContent)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 204 No Content\r\n"))
+	assert.NotContains(t, buf.String(), "Content-Length")
+
+	// Test: Headers passed to WriteHeaders replace the ones from Header
+	buf.Reset()
+	w = NewWriter(buf)
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("X-Kept", "1")
+	w.WriteStatusLine(Ok)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	w.WriteBody([]byte("hi"))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "X-Kept: 1\r\n")
+	assert.Contains(t, buf.String(), "Content-Type: text/plain\r\n")
+	assert.NotContains(t, buf.String(), "text/html")
+
+	// Test: Header set twice is sent once, with the last value
+	buf.Reset()
+	w = NewWriter(buf)
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "Content-Type: application/json\r\n")
+	assert.NotContains(t, buf.String(), "text/html")
+	assert.Equal(t, 1, strings.Count(buf.String(), "Content-Type"))
+
+	// Test: HEAD keeps the Content-Length of the body it drops
+	buf.Reset()
+	w = NewWriter(buf)
+	w.DiscardBody()
+	w.Write([]byte("hello"))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "Content-Length: 5\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+}
+
+func TestWriterChunking(t *testing.T) {
+	// Test: Body outgrowing the buffer switches to chunked, in chunks the
+	// size of the buffer
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetBufferSize(8)
+	w.Header().Set("Date", "Sun, 06 Nov 1994 08:49:37 GMT")
+	for _, part := range []string{"abc", "def", "ghi", "jkl", "m"} {
+		n, err := w.Write([]byte(part))
+		require.NoError(t, err)
+		assert.Equal(t, len(part), n)
+	}
+	assert.True(t, w.Committed())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nDate: Sun, 06 Nov 1994 08:49:37 GMT\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"6\r\nabcdef\r\n7\r\nghijklm\r\n0\r\n\r\n", buf.String())
+	assert.False(t, w.ClosesConnection())
+	assert.Equal(t, 13, w.BytesWritten())
+
+	// Test: Writes bigger than the buffer are sent as their own chunk
+	buf.Reset()
+	w = NewWriter(buf)
+	w.SetBufferSize(4)
+	w.Write([]byte("ab"))
+	w.Write([]byte("cdefghij"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n2\r\nab\r\n8\r\ncdefghij\r\n0\r\n\r\n"))
+
+	// Test: Flush commits the headers and sends what is buffered
+	buf.Reset()
+	w = NewWriter(buf)
+	w.Write([]byte("hello"))
+	require.NoError(t, w.Flush())
+	assert.Contains(t, buf.String(), "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n5\r\nhello\r\n"))
+	require.NoError(t, w.Flush())
+	w.Write([]byte(" world"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"))
+
+	// Test: Content-Length set by the handler is kept
+	buf.Reset()
+	w = NewWriter(buf)
+	w.SetBufferSize(4)
+	w.Header().Set("Content-Length", "10")
+	w.Write([]byte("0123456789"))
+	require.NoError(t, w.Finish())
+	assert.NotContains(t, buf.String(), "Transfer-Encoding")
+	assert.Contains(t, buf.String(), "Content-Length: 10\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n0123456789"))
+
+	// Test: Chunked headers written explicitly, body sent with Write
+	buf.Reset()
+	w = NewWriter(buf)
+	w.WriteStatusLine(Ok)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	w.Write([]byte("hi"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n2\r\nhi\r\n0\r\n\r\n"))
+}
+
+func TestWriterTrailers(t *testing.T) {
+	trailers := headers.NewHeaders()
+	trailers.Add("X-Checksum", "abc")
+
+	// Test: Buffered body sent chunked to carry the trailers
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.Header().Set("Date", "Sun, 06 Nov 1994 08:49:37 GMT")
+	w.Header().Set("Trailer", "X-Checksum")
+	w.Write([]byte("hello"))
+	require.NoError(t, w.WriteTrailers(trailers))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nDate: Sun, 06 Nov 1994 08:49:37 GMT\r\nTrailer: X-Checksum\r\n"+
+		"Transfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n", buf.String())
+	assert.ErrorIs(t, w.WriteTrailers(trailers), ErrWrongOrder)
+	_, err := w.WriteChunkedBodyDone()
+	assert.ErrorIs(t, err, ErrWrongOrder)
+
+	// Test: Trailer not announced
+	buf.Reset()
+	w = NewWriter(buf)
+	w.Header().Set("Trailer", "X-Other")
+	w.Write([]byte("hello"))
+	assert.ErrorIs(t, w.WriteTrailers(trailers), ErrUndeclaredTrailer)
+	assert.False(t, w.Committed())
+
+	// Test: Forbidden trailer, even when announced
+	forbidden := headers.NewHeaders()
+	forbidden.Add("content-length", "5")
+	w.Header().Add("Trailer", "Content-Length")
+	assert.ErrorIs(t, w.WriteTrailers(forbidden), ErrForbiddenTrailer)
+
+	// Test: Announced in a list, checked once the headers are written
+	buf.Reset()
+	w = NewWriter(buf)
+	w.WriteStatusLine(Ok)
+	h := headers.NewHeaders()
+	h.Add("Transfer-Encoding", "chunked")
+	h.Add("Trailer", "x-foo, x-checksum")
+	require.NoError(t, w.WriteHeaders(h))
+	w.WriteChunkedBody([]byte("hi"))
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.True(t, strings.HasSuffix(buf.String(), "2\r\nhi\r\n0\r\nX-Checksum: abc\r\n\r\n"))
+
+	// Test: Not a chunked response
+	w = NewWriter(&bytes.Buffer{})
+	w.Header().Set("Content-Length", "5")
+	w.Write([]byte("hello"))
+	assert.ErrorIs(t, w.WriteTrailers(trailers), ErrNotChunked)
+
+	w = NewWriter(&bytes.Buffer{})
+	w.WriteStatusLine(Ok)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.ErrorIs(t, w.WriteTrailers(headers.NewHeaders()), ErrNotChunked)
+	_, err = w.WriteChunkedBodyDone()
+	assert.ErrorIs(t, err, ErrNotChunked)
+}
+
+func TestWriterContentLength(t *testing.T) {
+	// Test: Writing past the declared length
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.WriteStatusLine(Ok)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hel"))
+	require.NoError(t, err)
+	// chunks can't get around the length
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	assert.ErrorIs(t, err, ErrNotChunked)
+	n, err := w.WriteBody([]byte("lo world"))
+	assert.ErrorIs(t, err, ErrBodyTooLong)
+	assert.Equal(t, 0, n)
+	_, err = w.Write([]byte("lo world"))
+	assert.ErrorIs(t, err, ErrBodyTooLong)
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhel"))
+
+	// Test: Body left short
+	assert.ErrorIs(t, w.Finish(), ErrBodyTooShort)
+	w.WriteBody([]byte("lo"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello"))
+
+	// Test: Buffered body not matching the length set by the handler,
+	// reported before anything is sent
+	for _, body := range []string{"hi", "hello world"} {
+		buf.Reset()
+		w = NewWriter(buf)
+		w.Header().Set("Content-Length", "5")
+		w.Write([]byte(body))
+		err = w.Finish()
+		assert.Error(t, err)
+		assert.False(t, w.Committed())
+		assert.Empty(t, buf.String())
+	}
+
+	// Test: Buffered write past the length set by the handler
+	buf.Reset()
+	w = NewWriter(buf)
+	w.Header().Set("Content-Length", "3")
+	n, err = w.Write([]byte("hello"))
+	assert.ErrorIs(t, err, ErrBodyTooLong)
+	assert.Equal(t, 0, n)
+	n, err = w.Write([]byte("hey"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhey"))
+
+	// Test: Invalid length
+	w = NewWriter(&bytes.Buffer{})
+	w.WriteStatusLine(Ok)
+	h := headers.NewHeaders()
+	h.Add("Content-Length", "five")
+	assert.ErrorIs(t, w.WriteHeaders(h), headers.ErrInvalidFieldValue)
+	assert.False(t, w.Committed())
+
+	// Test: HEAD and 304 declare a length without a body
+	buf.Reset()
+	w = NewWriter(buf)
+	w.DiscardBody()
+	w.Header().Set("Content-Length", "5")
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "Content-Length: 5\r\n")
+
+	w = NewWriter(&bytes.Buffer{})
+	w.WriteStatusLine(NotModified)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	require.NoError(t, w.Finish())
+}
+
+func TestWriterHooks(t *testing.T) {
+	// Test: Hooks run in order before the headers, seeing the status
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	var seen []string
+	w.OnWriteHeaders(func(statusCode StatusCode, h *headers.Headers) {
+		seen = append(seen, "first")
+		assert.Equal(t, NotFound, statusCode)
+		h.Set("X-Request-Id", "42")
+	})
+	w.OnWriteHeaders(func(statusCode StatusCode, h *headers.Headers) {
+		seen = append(seen, "second")
+		assert.Equal(t, "42", h.Get("X-Request-Id"))
+		h.Remove("Content-Type")
+	})
+	assert.Equal(t, StatusCode(0), w.Status())
+	require.NoError(t, w.WriteStatusLine(NotFound))
+	assert.Equal(t, NotFound, w.Status())
+	assert.Empty(t, seen)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("not found"))
+	assert.Equal(t, 9, w.BytesWritten())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, []string{"first", "second"}, seen)
+	assert.Contains(t, buf.String(), "X-Request-Id: 42\r\n")
+	assert.NotContains(t, buf.String(), "Content-Type")
+	assert.Equal(t, 9, w.BytesWritten())
+}
+
+// gzipFilter compresses the body and sets its Content-Encoding
+func gzipFilter(w *Writer) {
+	w.OnWriteHeaders(func(_ StatusCode, h *headers.Headers) {
+		h.Override("Content-Encoding", "gzip")
+	})
+	w.FilterBody(func(dst io.Writer) io.WriteCloser {
+		return gzip.NewWriter(dst)
+	})
+}
+
+// upperFilter upper cases the body
+type upperFilter struct {
+	dst io.Writer
+}
+
+func (u upperFilter) Write(p []byte) (int, error) {
+	return u.dst.Write(bytes.ToUpper(p))
+}
+
+func (u upperFilter) Close() error {
+	_, err := u.dst.Write([]byte("!"))
+	return err
+}
+
+// prefixFilter prefixes each write with "> "
+type prefixFilter struct {
+	dst io.Writer
+}
+
+func (f prefixFilter) Write(p []byte) (int, error) {
+	if _, err := f.dst.Write(append([]byte("> "), p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (prefixFilter) Close() error {
+	return nil
+}
+
+func TestWriterBodyFilter(t *testing.T) {
+	gunzip := func(data string) string {
+		r, err := gzip.NewReader(strings.NewReader(data))
+		require.NoError(t, err)
+		body, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	// Test: Buffered body compressed, sent with its compressed length
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	gzipFilter(w)
+	body := strings.Repeat("hello ", 100)
+	w.Write([]byte(body))
+	require.NoError(t, w.Finish())
+	head, compressed, _ := strings.Cut(buf.String(), "\r\n\r\n")
+	assert.Contains(t, head, "Content-Encoding: gzip")
+	assert.Contains(t, head, "Content-Length: "+strconv.Itoa(len(compressed))+"\r\n")
+	assert.Equal(t, len(compressed), w.BytesWritten())
+	assert.Equal(t, body, gunzip(compressed))
+
+	// Test: Chunked body compressed, WriteBody refused
+	buf.Reset()
+	w = NewWriter(buf)
+	gzipFilter(w)
+	require.NoError(t, w.WriteStatusLine(Ok))
+	w.Header().Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	_, err := w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	_, err = w.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	_, chunked, _ := strings.Cut(buf.String(), "\r\n\r\n")
+	var decoded strings.Builder
+	for {
+		sizeLine, rest, _ := strings.Cut(chunked, "\r\n")
+		size, err := strconv.ParseInt(sizeLine, 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			break
+		}
+		decoded.WriteString(rest[:size])
+		chunked = rest[size+2:]
+	}
+	assert.Equal(t, "hello world", gunzip(decoded.String()))
+
+	// Test: Filters applied from the last registered one
+	buf.Reset()
+	w = NewWriter(buf)
+	w.FilterBody(func(dst io.Writer) io.WriteCloser {
+		return upperFilter{dst}
+	})
+	w.FilterBody(func(dst io.Writer) io.WriteCloser {
+		return prefixFilter{dst}
+	})
+	w.Write([]byte("hi"))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "Content-Length: 5\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n> HI!"))
+
+	// Test: Filters set after the body started
+	w = NewWriter(&bytes.Buffer{})
+	w.Write([]byte("hi"))
+	assert.ErrorIs(t, w.FilterBody(func(dst io.Writer) io.WriteCloser { return upperFilter{dst} }), ErrWrongOrder)
+}
@@ -0,0 +1,255 @@
+package router
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/lealre/httpfromtcp/internal/headers"
+	"github.com/lealre/httpfromtcp/internal/request"
+	"github.com/lealre/httpfromtcp/internal/response"
+	"github.com/lealre/httpfromtcp/internal/server"
+)
+
+// Router dispatches requests to the handler registered for their method and
+// path. Patterns are made of "/" separated segments, each one either a
+// literal, a "{name}" wildcard matching a single segment or, as the last
+// segment only, a "{name...}" wildcard matching the rest of the path. The
+// captured values are available through request.Request.PathValue.
+type Router struct {
+	routes []*route
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []segment
+	handler  server.Handler
+}
+
+type segmentKind int
+
+// the order of the kinds is their precedence when several routes match
+const (
+	segmentRest segmentKind = iota
+	segmentWildcard
+	segmentLiteral
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for the requests with method whose path matches
+// pattern. It panics if the pattern is invalid or already registered for
+// method.
+func (rt *Router) Handle(method, pattern string, handler server.Handler) {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	for _, r := range rt.routes {
+		if r.method == method && r.pattern == pattern {
+			panic(fmt.Sprintf("route %s %s already registered", method, pattern))
+		}
+	}
+	rt.routes = append(rt.routes, &route{
+		method:   method,
+		pattern:  pattern,
+		segments: segments,
+		handler:  handler,
+	})
+}
+
+func (rt *Router) Get(pattern string, handler server.Handler) {
+	rt.Handle("GET", pattern, handler)
+}
+
+func (rt *Router) Post(pattern string, handler server.Handler) {
+	rt.Handle("POST", pattern, handler)
+}
+
+func (rt *Router) Put(pattern string, handler server.Handler) {
+	rt.Handle("PUT", pattern, handler)
+}
+
+func (rt *Router) Delete(pattern string, handler server.Handler) {
+	rt.Handle("DELETE", pattern, handler)
+}
+
+// Serve is the server.Handler of the router. Routes are matched against the
+// clean path of the request, ignoring the query. Requests for unknown
+// paths get a 404, and requests with a method the path doesn't support a 405
+// with an Allow header. Without explicit routes, HEAD is answered by the GET
+// handler and OPTIONS by listing the allowed methods.
+func (rt *Router) Serve(w *response.Writer, req *request.Request) {
+	path := req.URL.CleanPath
+	method := req.RequestLine.Method
+	if req.URL.Form == request.AsteriskForm {
+		// OPTIONS * asks about the server itself, not about a route
+		w.WriteStatusLine(response.NoContent)
+		w.WriteHeaders(headers.NewHeaders())
+		return
+	}
+
+	best, values := rt.find(method, path)
+	if best == nil && method == "HEAD" {
+		best, values = rt.find("GET", path)
+	}
+	if best != nil {
+		for name, value := range values {
+			req.SetPathValue(name, value)
+		}
+		best.handler(w, req)
+		return
+	}
+
+	allowed := rt.allowedMethods(path)
+	if len(allowed) == 0 {
+		writeText(w, response.NotFound, nil, "404 Not Found\n")
+		return
+	}
+	allow := strings.Join(allowed, ", ")
+	if method == "OPTIONS" {
+		w.WriteStatusLine(response.NoContent)
+		h := headers.NewHeaders()
+		h.Set("Allow", allow)
+		w.WriteHeaders(h)
+		return
+	}
+	writeText(w, response.MethodNotAllowed, &allow, "405 Method Not Allowed\n")
+}
+
+// allowedMethods lists, sorted, the methods registered for path, with the
+// ones the router answers by itself
+func (rt *Router) allowedMethods(path string) []string {
+	allowed := []string{}
+	for _, r := range rt.routes {
+		if _, ok := r.match(path); ok && !slices.Contains(allowed, r.method) {
+			allowed = append(allowed, r.method)
+		}
+	}
+	if len(allowed) == 0 {
+		return allowed
+	}
+	if slices.Contains(allowed, "GET") && !slices.Contains(allowed, "HEAD") {
+		allowed = append(allowed, "HEAD")
+	}
+	if !slices.Contains(allowed, "OPTIONS") {
+		allowed = append(allowed, "OPTIONS")
+	}
+	slices.Sort(allowed)
+	return allowed
+}
+
+// find returns the most specific route matching method and path
+func (rt *Router) find(method, path string) (*route, map[string]string) {
+	var best *route
+	var bestValues map[string]string
+	for _, r := range rt.routes {
+		if r.method != method {
+			continue
+		}
+		values, ok := r.match(path)
+		if !ok || (best != nil && !r.moreSpecific(best)) {
+			continue
+		}
+		best, bestValues = r, values
+	}
+	return best, bestValues
+}
+
+// match reports whether path matches the route pattern, along with the
+// values captured by its wildcards
+func (r *route) match(path string) (map[string]string, bool) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, false
+	}
+	parts := strings.Split(path[1:], "/")
+	values := map[string]string{}
+	for i, seg := range r.segments {
+		if seg.kind == segmentRest {
+			values[seg.value] = strings.Join(parts[i:], "/")
+			return values, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch seg.kind {
+		case segmentLiteral:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+		case segmentWildcard:
+			if parts[i] == "" {
+				return nil, false
+			}
+			values[seg.value] = parts[i]
+		}
+	}
+	if len(parts) != len(r.segments) {
+		return nil, false
+	}
+	return values, true
+}
+
+// moreSpecific reports whether r takes precedence over other when both
+// match the same path: the first segment that differs decides, literals
+// winning over wildcards
+func (r *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind > other.segments[i].kind
+		}
+	}
+	return len(r.segments) > len(other.segments)
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("invalid pattern %q: must start with /", pattern)
+	}
+	parts := strings.Split(pattern[1:], "/")
+	segments := make([]segment, 0, len(parts))
+	names := map[string]bool{}
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("invalid pattern %q: bad wildcard %q", pattern, part)
+			}
+			segments = append(segments, segment{kind: segmentLiteral, value: part})
+			continue
+		}
+
+		name := part[1 : len(part)-1]
+		kind := segmentWildcard
+		if strings.HasSuffix(name, "...") {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("invalid pattern %q: %s must be the last segment", pattern, part)
+			}
+			name = strings.TrimSuffix(name, "...")
+			kind = segmentRest
+		}
+		if name == "" || names[name] {
+			return nil, fmt.Errorf("invalid pattern %q: bad or duplicate wildcard name %q", pattern, part)
+		}
+		names[name] = true
+		segments = append(segments, segment{kind: kind, value: name})
+	}
+	return segments, nil
+}
+
+func writeText(w *response.Writer, statusCode response.StatusCode, allow *string, body string) {
+	w.WriteStatusLine(statusCode)
+	h := response.GetDefaultHeaders(len(body))
+	if allow != nil {
+		h.Set("Allow", *allow)
+	}
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(body))
+}
@@ -0,0 +1,116 @@
+package router
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/lealre/httpfromtcp/internal/request"
+	"github.com/lealre/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterDispatch(t *testing.T) {
+	r := New()
+	r.Get("/users", textHandler("list users"))
+	r.Get("/users/{id}", func(w *response.Writer, req *request.Request) {
+		textHandler("user "+req.PathValue("id"))(w, req)
+	})
+	r.Get("/users/me", textHandler("current user"))
+	r.Post("/users", textHandler("create user"))
+	r.Get("/static/{path...}", func(w *response.Writer, req *request.Request) {
+		textHandler("file "+req.PathValue("path"))(w, req)
+	})
+
+	// Test: Literal route
+	out := serve(t, r, "GET /users HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "list users"))
+
+	// Test: Same path, other method
+	out = serve(t, r, "POST /users HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "create user"))
+
+	// Test: Path parameter
+	out = serve(t, r, "GET /users/42 HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "user 42"))
+
+	// Test: Literal segment takes precedence over a wildcard
+	out = serve(t, r, "GET /users/me HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "current user"))
+
+	// Test: Query string is ignored when matching
+	out = serve(t, r, "GET /users/42?verbose=1 HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "user 42"))
+
+	// Test: Percent-encoded path
+	out = serve(t, r, "GET /users/john%20doe HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "user john doe"))
+
+	// Test: Dot segments resolved
+	out = serve(t, r, "GET /static/../users/./42 HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "user 42"))
+
+	// Test: Absolute form
+	out = serve(t, r, "GET http://example.com/users/42 HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "user 42"))
+
+	// Test: Rest wildcard
+	out = serve(t, r, "GET /static/css/site.css HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "file css/site.css"))
+
+	// Test: Unknown path
+	out = serve(t, r, "GET /unknown HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Empty wildcard segment doesn't match
+	out = serve(t, r, "GET /users/ HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Wrong method
+	out = serve(t, r, "DELETE /users HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, out, "Allow: GET, HEAD, OPTIONS, POST\r\n")
+
+	// Test: HEAD answered by the GET handler
+	out = serve(t, r, "HEAD /users/42 HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: OPTIONS answered from the routes
+	out = serve(t, r, "OPTIONS /users/42 HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"))
+	assert.Contains(t, out, "Allow: GET, HEAD, OPTIONS\r\n")
+
+	// Test: OPTIONS for the whole server
+	out = serve(t, r, "OPTIONS * HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"))
+}
+
+func TestRouterInvalidPatterns(t *testing.T) {
+	r := New()
+	assert.Panics(t, func() { r.Get("users", textHandler("")) })
+	assert.Panics(t, func() { r.Get("/users/{id", textHandler("")) })
+	assert.Panics(t, func() { r.Get("/files/{path...}/raw", textHandler("")) })
+	assert.Panics(t, func() { r.Get("/users/{id}/{id}", textHandler("")) })
+	assert.Panics(t, func() { r.Get("/users/{}", textHandler("")) })
+
+	r.Get("/users", textHandler(""))
+	assert.Panics(t, func() { r.Get("/users", textHandler("")) })
+}
+
+func textHandler(body string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.Ok)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+}
+
+func serve(t *testing.T, r *Router, raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	r.Serve(response.NewWriter(buf), req)
+	return buf.String()
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+type connPhase int
+
+const (
+	// waiting for the first byte of the next request
+	connPhaseIdle connPhase = iota
+	connPhaseHeaders
+	connPhaseBody
+)
+
+// timedConn applies the server timeouts to a connection, moving the read
+// deadline as the current request goes from idle to headers to body
+type timedConn struct {
+	net.Conn
+	s *Server
+
+	phase        connPhase
+	requestStart time.Time
+	readDeadline time.Time
+	bodyStart    time.Time
+	bodyRead     int
+	timedOut     bool
+}
+
+func newTimedConn(conn net.Conn, s *Server) *timedConn {
+	return &timedConn{Conn: conn, s: s}
+}
+
+// awaitRequest prepares the connection to read the next request. idle tells
+// whether a previous request was served, so the idle timeout applies.
+func (c *timedConn) awaitRequest(idle bool) {
+	c.phase = connPhaseIdle
+	c.requestStart = time.Time{}
+	c.bodyRead = 0
+	c.timedOut = false
+
+	timeout := c.s.readHeaderTimeout
+	if idle {
+		timeout = c.s.idleTimeout
+	}
+	if timeout == 0 {
+		timeout = c.s.readTimeout
+	}
+	c.Conn.SetReadDeadline(deadline(time.Now(), timeout))
+	c.Conn.SetWriteDeadline(time.Time{})
+}
+
+// startBody is called once the request headers are read, before the handler
+// runs: from now on the read timeout, the minimum body rate and the write
+// timeout apply.
+func (c *timedConn) startBody() {
+	now := time.Now()
+	if c.phase == connPhaseIdle {
+		// the whole request was already buffered
+		c.requestStart = now
+	}
+	c.phase = connPhaseBody
+	c.bodyStart = now
+	c.readDeadline = deadline(c.requestStart, c.s.readTimeout)
+	c.Conn.SetReadDeadline(c.readDeadline)
+	c.Conn.SetWriteDeadline(deadline(now, c.s.writeTimeout))
+}
+
+// receivedRequest reports whether any byte of the current request arrived
+func (c *timedConn) receivedRequest() bool {
+	return c.phase != connPhaseIdle
+}
+
+func (c *timedConn) Read(p []byte) (int, error) {
+	if c.phase == connPhaseBody && c.s.minBodyRate > 0 {
+		c.Conn.SetReadDeadline(c.bodyRateDeadline())
+	}
+
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		switch c.phase {
+		case connPhaseIdle:
+			c.phase = connPhaseHeaders
+			c.requestStart = time.Now()
+			timeout := c.s.readHeaderTimeout
+			if timeout == 0 {
+				timeout = c.s.readTimeout
+			}
+			c.Conn.SetReadDeadline(deadline(c.requestStart, timeout))
+		case connPhaseBody:
+			c.bodyRead += n
+		}
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		c.timedOut = true
+	}
+	return n, err
+}
+
+// bodyRateDeadline is the time by which the next body byte must arrive for
+// the upload to keep the minimum rate, once the grace period is over
+func (c *timedConn) bodyRateDeadline() time.Time {
+	expected := time.Duration(float64(c.bodyRead+1) / float64(c.s.minBodyRate) * float64(time.Second))
+	rateDeadline := c.bodyStart.Add(c.s.minBodyRateGrace + expected)
+	if !c.readDeadline.IsZero() && c.readDeadline.Before(rateDeadline) {
+		return c.readDeadline
+	}
+	return rateDeadline
+}
+
+// deadline returns the deadline timeout after start, or the zero time (no
+// deadline) for a zero timeout
+func deadline(start time.Time, timeout time.Duration) time.Time {
+	if timeout == 0 {
+		return time.Time{}
+	}
+	return start.Add(timeout)
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lealre/httpfromtcp/internal/request"
+	"github.com/lealre/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readBody answers with the request body, or nothing if it can't be read
+func readBody(w *response.Writer, req *request.Request) {
+	body, err := req.ReadBody()
+	if err != nil {
+		return
+	}
+	w.WriteStatusLine(response.Ok)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// assertClosed checks the server closed the connection without a response
+func assertClosed(t *testing.T, r *bufio.Reader) {
+	t.Helper()
+	_, err := r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+// assertTimeout checks the server answered with a 408 and closed the
+// connection
+func assertTimeout(t *testing.T, r *bufio.Reader) {
+	t.Helper()
+	resp, _ := readResponse(t, r)
+	assert.Equal(t, http.StatusRequestTimeout, resp.StatusCode)
+	assert.True(t, resp.Close)
+	assertClosed(t, r)
+}
+
+func TestTimeouts(t *testing.T) {
+	_, addr := startServer(t, readBody,
+		WithReadHeaderTimeout(100*time.Millisecond),
+		WithIdleTimeout(400*time.Millisecond),
+		WithReadTimeout(300*time.Millisecond),
+	)
+	request := "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello"
+
+	// Test: A connection never sending a request is closed silently
+	conn := dial(t, addr)
+	start := time.Now()
+	assertClosed(t, bufio.NewReader(conn))
+	assert.Less(t, time.Since(start), 300*time.Millisecond)
+
+	// Test: Headers sent too slowly get a 408
+	conn = dial(t, addr)
+	conn.Write([]byte("POST / HTTP/1.1\r\nHo"))
+	start = time.Now()
+	assertTimeout(t, bufio.NewReader(conn))
+	assert.Less(t, time.Since(start), 300*time.Millisecond)
+
+	// Test: The idle timeout applies between requests, past which the
+	// connection is closed silently
+	conn = dial(t, addr)
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(request))
+	_, body := readResponse(t, reader)
+	assert.Equal(t, "hello", body)
+	time.Sleep(200 * time.Millisecond)
+	conn.Write([]byte(request))
+	_, body = readResponse(t, reader)
+	assert.Equal(t, "hello", body)
+	start = time.Now()
+	assertClosed(t, reader)
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+
+	// Test: A body not sent within the read timeout gets a 408
+	conn = dial(t, addr)
+	conn.Write([]byte("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhel"))
+	start = time.Now()
+	assertTimeout(t, bufio.NewReader(conn))
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestMinBodyRate(t *testing.T) {
+	_, addr := startServer(t, readBody,
+		WithReadTimeout(5*time.Second),
+		WithMinBodyRate(100, 100*time.Millisecond),
+	)
+
+	// Test: A body keeping the rate is read
+	conn := dial(t, addr)
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\n\r\nab"))
+	time.Sleep(50 * time.Millisecond)
+	conn.Write([]byte("cd"))
+	_, body := readResponse(t, reader)
+	assert.Equal(t, "abcd", body)
+
+	// Test: A stalled body fails once the grace period is over, well
+	// before the read timeout
+	conn = dial(t, addr)
+	conn.Write([]byte("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 1000\r\n\r\nab"))
+	start := time.Now()
+	assertTimeout(t, bufio.NewReader(conn))
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 100*time.Millisecond)
+	require.Less(t, elapsed, time.Second)
+}
+
+func TestTimeoutAfterStatusLine(t *testing.T) {
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		// the status line is only sent with the headers, so the
+		// response can still be replaced
+		w.WriteStatusLine(response.Ok)
+		if _, err := req.ReadBody(); err != nil {
+			return
+		}
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	}, WithReadTimeout(100*time.Millisecond))
+
+	// Test: A body timing out once the handler chose a status gets a 408
+	conn := dial(t, addr)
+	conn.Write([]byte("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhel"))
+	assertTimeout(t, bufio.NewReader(conn))
+}
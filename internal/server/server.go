@@ -1,25 +1,176 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"runtime/debug"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/lealre/httpfromtcp/internal/headers"
 	"github.com/lealre/httpfromtcp/internal/request"
 	"github.com/lealre/httpfromtcp/internal/response"
 )
 
+// ErrHandlerPanic is passed to the error handler when the handler panicked
+// before writing its response
+var ErrHandlerPanic = errors.New("handler panicked")
+
+// ErrBadResponse is passed to the error handler when the response of the
+// handler couldn't be written, like when its headers are invalid
+var ErrBadResponse = errors.New("invalid response")
+
 // Server is an HTTP 1.1 server
 type Server struct {
 	listener net.Listener
 	handler  Handler
 	closed   atomic.Bool
+	limits   request.Limits
+	onError  ErrorHandler
+	// serverHeader is the value of the Server header, not sent when empty
+	serverHeader string
+	// responseBufferSize is passed to response.Writer.SetBufferSize when set
+	responseBufferSize int
+	encodedSlashes     request.EncodedSlashes
+
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	minBodyRate       int
+	minBodyRateGrace  time.Duration
+
+	mu           sync.Mutex
+	shuttingDown bool
+	// conns tracks the open connections, mapped to whether they are idle,
+	// i.e. waiting for the next request
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
 }
 
 type Handler func(w *response.Writer, req *request.Request)
 
-func Serve(port int, handler Handler) (*Server, error) {
+// Middleware wraps a Handler with behaviour shared by several handlers. It
+// can observe the response through the writer, change the headers before
+// they are sent with response.Writer.OnWriteHeaders, and transform the body
+// with response.Writer.FilterBody.
+type Middleware func(Handler) Handler
+
+// Chain wraps handler with middlewares, the first one being the outermost:
+// it sees the request first and the response last.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// ErrorHandler writes the response sent when a request can't be parsed.
+// statusCode is the status matching err, which wraps one of the request
+// package errors.
+type ErrorHandler func(w *response.Writer, statusCode response.StatusCode, err error)
+
+// Option configures optional behaviour of a Server
+type Option func(*Server)
+
+// WithLimits sets the limits enforced while parsing requests. Requests going
+// over them are answered with 414, 431 or 413.
+func WithLimits(limits request.Limits) Option {
+	return func(s *Server) {
+		s.limits = limits
+	}
+}
+
+// WithErrorHandler replaces the default plain text response written when a
+// request can't be parsed
+func WithErrorHandler(handler ErrorHandler) Option {
+	return func(s *Server) {
+		s.onError = handler
+	}
+}
+
+// WithServerHeader makes the responses carry a Server header with value,
+// unless the handler set one
+func WithServerHeader(value string) Option {
+	return func(s *Server) {
+		s.serverHeader = value
+	}
+}
+
+// WithResponseBufferSize sets how much of a response body is buffered
+// before the headers are written, see response.Writer.SetBufferSize
+func WithResponseBufferSize(size int) Option {
+	return func(s *Server) {
+		s.responseBufferSize = size
+	}
+}
+
+// WithEncodedSlashes sets the policy for percent-encoded slashes in request
+// paths, request.DecodeSlashes by default. Requests rejected by the policy
+// are answered with a 400.
+func WithEncodedSlashes(policy request.EncodedSlashes) Option {
+	return func(s *Server) {
+		s.encodedSlashes = policy
+	}
+}
+
+// WithCanonicalRedirect makes requests whose path isn't canonical, like
+// /a/./b/../c or //a, be redirected to their clean path with a 308 instead
+// of being handled
+func WithCanonicalRedirect() Option {
+	return func(s *Server) {
+		s.handler = redirectToCanonical(s.handler)
+	}
+}
+
+// WithReadHeaderTimeout limits the time to read the request line and the
+// headers, counted from the first byte of the request. When zero, the read
+// timeout is used.
+func WithReadHeaderTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.readHeaderTimeout = timeout
+	}
+}
+
+// WithReadTimeout limits the time to read a whole request, body included
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.readTimeout = timeout
+	}
+}
+
+// WithWriteTimeout limits the time to write a response, counted from the
+// end of the request headers
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.writeTimeout = timeout
+	}
+}
+
+// WithIdleTimeout limits how long a kept alive connection waits for the
+// next request. When zero, the read timeout is used.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = timeout
+	}
+}
+
+// WithMinBodyRate makes reading a request body fail when, after grace, the
+// client sends it slower than bytesPerSecond on average
+func WithMinBodyRate(bytesPerSecond int, grace time.Duration) Option {
+	return func(s *Server) {
+		s.minBodyRate = bytesPerSecond
+		s.minBodyRateGrace = grace
+	}
+}
+
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
@@ -27,11 +178,19 @@ func Serve(port int, handler Handler) (*Server, error) {
 	s := &Server{
 		listener: listener,
 		handler:  handler,
+		limits:   request.DefaultLimits(),
+		onError:  defaultErrorHandler,
+		conns:    map[net.Conn]bool{},
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 	go s.listen()
 	return s, nil
 }
 
+// Close stops accepting connections. The connections already accepted are
+// left running, see Shutdown to drain them.
 func (s *Server) Close() error {
 	s.closed.Store(true)
 	if s.listener != nil {
@@ -40,6 +199,83 @@ func (s *Server) Close() error {
 	return nil
 }
 
+// Shutdown stops accepting connections, closes the idle ones and makes the
+// busy ones close after their current response. It waits for them to finish
+// until ctx is done, then force-closes the remaining connections and returns
+// how many were aborted along with the context error.
+func (s *Server) Shutdown(ctx context.Context) (int, error) {
+	err := s.Close()
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+
+	s.mu.Lock()
+	s.shuttingDown = true
+	for conn, idle := range s.conns {
+		if idle {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return 0, err
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		return len(s.conns), ctx.Err()
+	}
+}
+
+// trackConn registers a new connection, reporting false if the server is
+// shutting down and the connection must not be served
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown {
+		return false
+	}
+	s.conns[conn] = false
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+	s.wg.Done()
+}
+
+// setIdle marks conn as idle or busy. Once the server is shutting down,
+// it reports false instead of letting the connection go idle.
+func (s *Server) setIdle(conn net.Conn, idle bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if idle && s.shuttingDown {
+		return false
+	}
+	s.conns[conn] = idle
+	return true
+}
+
+// isShuttingDown reports whether Shutdown was called
+func (s *Server) isShuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shuttingDown
+}
+
 func (s *Server) listen() {
 	for {
 		conn, err := s.listener.Accept()
@@ -50,20 +286,257 @@ func (s *Server) listen() {
 			log.Printf("Error accepting connection: %v", err)
 			continue
 		}
+		if !s.trackConn(conn) {
+			conn.Close()
+			continue
+		}
 		go s.handle(conn)
 	}
 }
 
-func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	resp := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		resp.WriteStatusLine(response.InternalServerError)
-		body := []byte("error reading the request")
-		resp.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		resp.WriteBody(body)
-		return
+// lingerTimeout is how long the unread input is discarded before closing a
+// connection after an error response
+const lingerTimeout = 500 * time.Millisecond
+
+// maxDrainBytes is how much of an unread request body is discarded to reuse
+// the connection; the connection is closed instead for bigger leftovers
+const maxDrainBytes = 256 * 1024
+
+// handle serves the requests sent on conn until the client or the handler
+// asks to close it, following the HTTP/1.1 persistence rules. Pipelined
+// requests are answered one after the other, in the order they were sent.
+func (s *Server) handle(rawConn net.Conn) {
+	defer s.untrackConn(rawConn)
+	defer rawConn.Close()
+	conn := newTimedConn(rawConn, s)
+	reader := request.NewReader(conn, s.limits)
+	reader.SetEncodedSlashes(s.encodedSlashes)
+	for served := false; ; served = true {
+		if !s.setIdle(rawConn, true) {
+			return
+		}
+		conn.awaitRequest(served)
+		resp := s.newWriter(conn)
+		req, err := reader.ReadRequest()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				// the client closed the connection between requests, or
+				// the server closed it while shutting down
+				return
+			}
+			if conn.timedOut && !conn.receivedRequest() {
+				// the connection stayed idle for too long
+				return
+			}
+			if errors.Is(err, request.ErrBodyNotConsumed) {
+				// the server's fault, not something to answer the client
+				log.Printf("Closing connection: %v", err)
+				return
+			}
+			s.setIdle(rawConn, false)
+			conn.SetWriteDeadline(deadline(time.Now(), s.writeTimeout))
+			resp.CloseAfterResponse()
+			statusCode := statusForError(err)
+			if conn.timedOut {
+				statusCode = response.RequestTimeout
+			}
+			s.writeError(resp, statusCode, err)
+			lingerClose(conn)
+			return
+		}
+		s.setIdle(rawConn, false)
+		conn.startBody()
+
+		if !keepAlive(req) {
+			resp.CloseAfterResponse()
+		}
+		if req.RequestLine.Method == "HEAD" {
+			resp.DiscardBody()
+		}
+		if !s.runHandler(resp, req) {
+			if resp.Committed() {
+				// part of the response is already sent, it can't be fixed
+				abort(conn)
+				return
+			}
+			resp = s.newWriter(conn)
+			resp.CloseAfterResponse()
+			s.writeError(resp, response.InternalServerError, ErrHandlerPanic)
+			return
+		}
+		if conn.timedOut && !resp.Committed() {
+			// the handler gave up on a body that took too long to arrive
+			resp = s.newWriter(conn)
+			resp.CloseAfterResponse()
+			s.writeError(resp, response.RequestTimeout, os.ErrDeadlineExceeded)
+			return
+		}
+		if err := resp.Finish(); err != nil {
+			if resp.Committed() {
+				if errors.Is(err, response.ErrBodyTooShort) {
+					// the client would wait for the rest of the body
+					log.Printf("Aborting the response to %s %s: %v",
+						req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+					abort(conn)
+				}
+				return
+			}
+			log.Printf("Error writing the response to %s %s: %v",
+				req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+			resp = s.newWriter(conn)
+			resp.CloseAfterResponse()
+			s.writeError(resp, response.InternalServerError, fmt.Errorf("%w: %w", ErrBadResponse, err))
+			return
+		}
+		if resp.ClosesConnection() {
+			return
+		}
+		if reader.DiscardBody(maxDrainBytes) != nil {
+			return
+		}
+	}
+}
+
+// redirectToCanonical answers the requests with a path that isn't canonical
+// with a redirect to the canonical one, passing the others to next
+func redirectToCanonical(next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if req.URL.CleanPath == req.URL.Path {
+			next(w, req)
+			return
+		}
+		w.WriteStatusLine(response.PermanentRedirect)
+		w.Header().Set("Location", req.URL.CanonicalTarget())
+	}
+}
+
+// newWriter returns the writer of a response sent on conn
+func (s *Server) newWriter(conn net.Conn) *response.Writer {
+	w := response.NewWriter(conn)
+	if s.responseBufferSize > 0 {
+		w.SetBufferSize(s.responseBufferSize)
 	}
+	w.OnWriteHeaders(func(_ response.StatusCode, h *headers.Headers) {
+		// checked as late as possible, so the responses of the handlers
+		// running when Shutdown is called tell the client to close too
+		if s.isShuttingDown() {
+			h.Override("Connection", "close")
+		}
+	})
+	if s.serverHeader != "" {
+		w.OnWriteHeaders(func(_ response.StatusCode, h *headers.Headers) {
+			if h.Get("Server") == "" {
+				h.Add("Server", s.serverHeader)
+			}
+		})
+	}
+	return w
+}
+
+// writeError sends the response of the error handler
+func (s *Server) writeError(w *response.Writer, statusCode response.StatusCode, err error) {
+	s.onError(w, statusCode, err)
+	w.Finish()
+}
+
+// runHandler calls the handler, recovering from a panic so it only affects
+// the current connection. It reports whether the handler returned normally.
+func (s *Server) runHandler(resp *response.Writer, req *request.Request) (ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("Panic serving %s %s %s: %v\n%s",
+				req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion, v, debug.Stack())
+			ok = false
+		}
+	}()
+	defer func() {
+		// uploaded files spilled to disk only live as long as the handler
+		if req.MultipartForm != nil {
+			if err := req.MultipartForm.RemoveAll(); err != nil {
+				log.Printf("Error removing the uploaded files: %v", err)
+			}
+		}
+	}()
 	s.handler(resp, req)
+	return true
+}
+
+// abort closes conn with a reset, so the client can't mistake a truncated
+// response for a complete one
+func abort(conn net.Conn) {
+	if timed, ok := conn.(*timedConn); ok {
+		conn = timed.Conn
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// lingerClose stops writing to conn and discards what the client is still
+// sending, so unread data doesn't make the kernel reset the connection
+// before the client got the response
+func lingerClose(conn net.Conn) {
+	if timed, ok := conn.(*timedConn); ok {
+		conn = timed.Conn
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+	io.Copy(io.Discard, io.LimitReader(conn, maxDrainBytes))
+}
+
+// keepAlive reports whether the client allows the connection to be reused
+// after answering req. Only HTTP/1.1 requests get this far, for which
+// persistence is the default.
+func keepAlive(req *request.Request) bool {
+	return !req.Headers.HasToken("connection", "close")
+}
+
+// statusForError maps an error returned while parsing a request to the
+// status code of the response
+func statusForError(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ErrRequestLineTooLong):
+		return response.URITooLong
+	case errors.Is(err, request.ErrHeadersTooLarge):
+		return response.RequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.ContentTooLarge
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return response.HTTPVersionNotSupported
+	case errors.Is(err, request.ErrUnsupportedCoding):
+		return response.NotImplemented
+	case errors.Is(err, request.ErrMalformedRequestLine),
+		errors.Is(err, request.ErrInvalidMethod),
+		errors.Is(err, request.ErrInvalidTarget),
+		errors.Is(err, request.ErrInvalidHeader),
+		errors.Is(err, request.ErrBadContentLength),
+		errors.Is(err, request.ErrConflictingFraming),
+		errors.Is(err, request.ErrBadTransferEncoding),
+		errors.Is(err, request.ErrMalformedChunk),
+		errors.Is(err, request.ErrIncompleteRequest),
+		errors.Is(err, request.ErrTruncatedBody):
+		return response.BadRequest
+	default:
+		return response.InternalServerError
+	}
+}
+
+func defaultErrorHandler(w *response.Writer, statusCode response.StatusCode, err error) {
+	message := err.Error()
+	switch statusCode {
+	case response.RequestTimeout:
+		message = "timed out reading the request"
+	case response.InternalServerError:
+		message = "error reading the request"
+		if errors.Is(err, ErrHandlerPanic) || errors.Is(err, ErrBadResponse) {
+			message = "internal server error"
+		}
+	}
+	body := []byte(message)
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
 }
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lealre/httpfromtcp/internal/headers"
+	"github.com/lealre/httpfromtcp/internal/request"
+	"github.com/lealre/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer serves handler on a free port, returning the server and its
+// address
+func startServer(t *testing.T, handler Handler, opts ...Option) (*Server, string) {
+	t.Helper()
+	s, err := Serve(0, handler, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, s.listener.Addr().String()
+}
+
+// dial opens a connection to addr, closed at the end of the test
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readResponse reads a response from r, returning it with its body
+func readResponse(t *testing.T, r *bufio.Reader) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+// waitFor polls cond until it's true, failing the test after a while
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	require.Eventually(t, cond, 2*time.Second, 5*time.Millisecond)
+}
+
+// echoTarget answers with the request target, leaving the body unread
+func echoTarget(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.RequestTarget)
+	w.WriteStatusLine(response.Ok)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestKeepAlive(t *testing.T) {
+	_, addr := startServer(t, echoTarget)
+
+	// Test: Requests served on the same connection, unread bodies drained
+	conn := dial(t, addr)
+	reader := bufio.NewReader(conn)
+	requests := []struct {
+		target string
+		data   string
+	}{
+		{"/first", "POST /first HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello"},
+		{"/second", "POST /second HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n"},
+		{"/third", "GET /third HTTP/1.1\r\nHost: x\r\n\r\n"},
+	}
+	for _, req := range requests {
+		conn.Write([]byte(req.data))
+		resp, body := readResponse(t, reader)
+		assert.Equal(t, req.target, body)
+		assert.False(t, resp.Close)
+	}
+
+	// Test: Pipelined requests answered in the order they were sent
+	pipelined := ""
+	for _, req := range requests {
+		pipelined += req.data
+	}
+	conn.Write([]byte(pipelined))
+	for _, req := range requests {
+		_, body := readResponse(t, reader)
+		assert.Equal(t, req.target, body)
+	}
+
+	// Test: Connection close from the client
+	conn.Write([]byte("GET /last HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"))
+	resp, body := readResponse(t, reader)
+	assert.Equal(t, "/last", body)
+	assert.True(t, resp.Close)
+	_, err := reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Bodies closed or replaced by the handler are still drained
+	handlers := map[string]Handler{
+		"closed": func(w *response.Writer, req *request.Request) {
+			defer req.Body.Close()
+			echoTarget(w, req)
+		},
+		"replaced": func(w *response.Writer, req *request.Request) {
+			req.Body = io.NopCloser(strings.NewReader(""))
+			echoTarget(w, req)
+		},
+	}
+	for name, handler := range handlers {
+		_, addr := startServer(t, handler)
+		conn := dial(t, addr)
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello" +
+			"GET /b HTTP/1.1\r\nHost: x\r\n\r\n"))
+		for _, target := range []string{"/a", "/b"} {
+			resp, body := readResponse(t, reader)
+			assert.Equal(t, 200, resp.StatusCode, name)
+			assert.Equal(t, target, body, name)
+			assert.False(t, resp.Close, name)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	// Test: Idle connections are closed right away
+	s, addr := startServer(t, echoTarget)
+	conn := dial(t, addr)
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	resp, body := readResponse(t, reader)
+	assert.Equal(t, "/", body)
+	assert.False(t, resp.Close)
+	aborted, err := s.Shutdown(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, aborted)
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+
+	// Test: In-flight requests finish, telling the client to close
+	started, release := make(chan struct{}), make(chan struct{})
+	s, addr = startServer(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+		echoTarget(w, req)
+	})
+	conn = dial(t, addr)
+	reader = bufio.NewReader(conn)
+	conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: x\r\n\r\n"))
+	<-started
+	type result struct {
+		aborted int
+		err     error
+	}
+	shutdown := make(chan result)
+	go func() {
+		aborted, err := s.Shutdown(context.Background())
+		shutdown <- result{aborted, err}
+	}()
+	waitFor(t, s.isShuttingDown)
+	close(release)
+	resp, body = readResponse(t, reader)
+	assert.Equal(t, "/slow", body)
+	assert.True(t, resp.Close)
+	assert.Equal(t, result{0, nil}, <-shutdown)
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Connections still busy after the deadline are aborted
+	started, release = make(chan struct{}), make(chan struct{})
+	defer close(release)
+	s, addr = startServer(t, func(w *response.Writer, req *request.Request) {
+		started <- struct{}{}
+		<-release
+	})
+	for range 2 {
+		conn = dial(t, addr)
+		conn.Write([]byte("GET /stuck HTTP/1.1\r\nHost: x\r\n\r\n"))
+		<-started
+	}
+	dial(t, addr)
+	waitFor(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		idle := 0
+		for _, isIdle := range s.conns {
+			if isIdle {
+				idle++
+			}
+		}
+		return len(s.conns) == 3 && idle == 1
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	aborted, err = s.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 2, aborted)
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestHandlerPanic(t *testing.T) {
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/before":
+			panic("before the response")
+		case "/status":
+			// the status line alone isn't sent yet
+			w.WriteStatusLine(response.Ok)
+			panic("before the headers")
+		case "/after":
+			w.WriteStatusLine(response.Ok)
+			w.WriteHeaders(response.GetDefaultHeaders(100))
+			w.WriteBody([]byte("partial"))
+			panic("after the response")
+		}
+		echoTarget(w, req)
+	})
+
+	// Test: Panic before the response is sent, answered with a 500
+	for _, target := range []string{"/before", "/status"} {
+		conn := dial(t, addr)
+		conn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: x\r\n\r\n"))
+		resp, _ := readResponse(t, bufio.NewReader(conn))
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, target)
+		assert.True(t, resp.Close)
+	}
+
+	// Test: Panic once the response is committed, the connection is aborted
+	conn := dial(t, addr)
+	conn.Write([]byte("GET /after HTTP/1.1\r\nHost: x\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = io.ReadAll(resp.Body)
+	assert.Error(t, err)
+
+	// Test: The server keeps serving
+	conn = dial(t, addr)
+	conn.Write([]byte("GET /next HTTP/1.1\r\nHost: x\r\n\r\n"))
+	_, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "/next", body)
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *response.Writer, req *request.Request) {
+				calls = append(calls, name+" in")
+				next(w, req)
+				calls = append(calls, name+" out")
+			}
+		}
+	}
+	var status response.StatusCode
+	var written int
+	observe := func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			w.OnWriteHeaders(func(_ response.StatusCode, h *headers.Headers) {
+				h.Set("X-Observed", "yes")
+			})
+			next(w, req)
+			status, written = w.Status(), w.BytesWritten()
+		}
+	}
+	handler := func(w *response.Writer, req *request.Request) {
+		calls = append(calls, "handler")
+		w.WriteStatusLine(response.Created)
+		w.Write([]byte("created"))
+	}
+
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	Chain(handler, trace("outer"), observe, trace("inner"))(w, req)
+	require.NoError(t, w.Finish())
+
+	assert.Equal(t, []string{"outer in", "inner in", "handler", "inner out", "outer out"}, calls)
+	assert.Equal(t, response.Created, status)
+	assert.Equal(t, 7, written)
+	assert.Contains(t, buf.String(), "X-Observed: yes\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\ncreated"))
+}